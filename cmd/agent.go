@@ -0,0 +1,25 @@
+// Package cmd used for various command configs
+package cmd
+
+import (
+	"github.com/shine-o/shine.engine.packet-sniffer/service"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// agentCmd represents the agent command
+var agentCmd = &cobra.Command{
+	Use:   "agent",
+	Short: "Capture packets and stream them to a collector",
+	Run:   service.Agent,
+}
+
+func init() {
+	rootCmd.AddCommand(agentCmd)
+
+	agentCmd.Flags().String("name", "", "name flows captured by this agent are tagged with (default is the hostname)")
+	agentCmd.Flags().String("collector", "", "address of the sniffer collect instance, e.g: 10.0.0.5:7071")
+
+	_ = viper.BindPFlag("agent.name", agentCmd.Flags().Lookup("name"))
+	_ = viper.BindPFlag("agent.collector", agentCmd.Flags().Lookup("collector"))
+}
@@ -4,6 +4,7 @@ package cmd
 import (
 	"github.com/shine-o/shine.engine.packet-sniffer/service"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 )
 
 // captureCmd represents the capture command
@@ -15,4 +16,8 @@ var captureCmd = &cobra.Command{
 
 func init() {
 	rootCmd.AddCommand(captureCmd)
+
+	captureCmd.Flags().String("input", "", "read packets from a pcap or pcapng stream instead of the network interfaces, - reads from stdin")
+
+	_ = viper.BindPFlag("capture.input", captureCmd.Flags().Lookup("input"))
 }
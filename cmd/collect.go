@@ -0,0 +1,23 @@
+// Package cmd used for various command configs
+package cmd
+
+import (
+	"github.com/shine-o/shine.engine.packet-sniffer/service"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// collectCmd represents the collect command
+var collectCmd = &cobra.Command{
+	Use:   "collect",
+	Short: "Decode packets streamed by agents",
+	Run:   service.Collect,
+}
+
+func init() {
+	rootCmd.AddCommand(collectCmd)
+
+	collectCmd.Flags().String("listen", "", "address agents connect to (default is :7071)")
+
+	_ = viper.BindPFlag("collect.listen", collectCmd.Flags().Lookup("listen"))
+}
@@ -6,11 +6,15 @@ import (
 	"github.com/spf13/cobra"
 )
 
-// captureCmd represents the capture command
+// decodeCmd represents the decode command
 var decodeCmd = &cobra.Command{
-	Use:   "decode",
+	Use:   "decode <file.pcap|file.pcapng|->",
 	Short: "Decode file with packet data",
-	Run:   service.Capture,
+	Long: `Decode file with packet data, - reads from stdin
+
+e.g: ssh server tcpdump -U -w - "tcp portrange 9000-9600" | sniffer decode -`,
+	Args: cobra.ExactArgs(1),
+	Run:  service.Decode,
 }
 
 func init() {
@@ -0,0 +1,18 @@
+// Package cmd used for various command configs
+package cmd
+
+import (
+	"github.com/shine-o/shine.engine.packet-sniffer/service"
+	"github.com/spf13/cobra"
+)
+
+// proxyCmd represents the proxy command
+var proxyCmd = &cobra.Command{
+	Use:   "proxy",
+	Short: "Forward client connections to the servers and decode them, without capturing packets",
+	Run:   service.Proxy,
+}
+
+func init() {
+	rootCmd.AddCommand(proxyCmd)
+}
@@ -0,0 +1,33 @@
+// Package cmd used for various command configs
+package cmd
+
+import (
+	"github.com/shine-o/shine.engine.packet-sniffer/service"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// replayCmd represents the replay command
+var replayCmd = &cobra.Command{
+	Use:   "replay <file.pcap|file.pcapng>",
+	Short: "Replay file with packet data at the speed it was captured",
+	Long: `Replay file with packet data at the speed it was captured
+
+While replaying, the websocket server also accepts:
+  /replay/pause
+  /replay/resume
+  /replay/speed?x=2
+  /replay/jump?to=90s (offset from the first packet, or a RFC3339 timestamp)`,
+	Args: cobra.ExactArgs(1),
+	Run:  service.Replay,
+}
+
+func init() {
+	rootCmd.AddCommand(replayCmd)
+
+	replayCmd.Flags().Float64("speed", 1, "replay speed multiplier, e.g: 0.5, 2, 10")
+	replayCmd.Flags().String("from", "", "skip ahead to an offset from the first packet (e.g: 90s) or a RFC3339 timestamp")
+
+	_ = viper.BindPFlag("replay.speed", replayCmd.Flags().Lookup("speed"))
+	_ = viper.BindPFlag("replay.from", replayCmd.Flags().Lookup("from"))
+}
@@ -60,26 +60,74 @@ func initConfig() {
 		fmt.Println("Using config file:", viper.ConfigFileUsed())
 	}
 
-	required := []string{
-		"network.interface",
-	}
-
-	for _, v := range required {
-		if !viper.IsSet(v) {
-			panic(fmt.Sprintf("required config parameter is missing: %v", v))
-		}
+	if !viper.IsSet("network.interface") && !viper.IsSet("network.interfaces") {
+		panic("required config parameter is missing: network.interface or network.interfaces")
 	}
 
 	viper.SetDefault("network.portRange.start", 9000)
 
 	viper.SetDefault("network.portRange.end", 9600)
 
-	viper.SetDefault("network.interface", 65536)
+	viper.SetDefault("network.snaplen", 65536)
+
+	viper.SetDefault("network.backend", "pcap")
+
+	viper.SetDefault("network.decoder", "packet")
+
+	viper.SetDefault("network.afpacket.bufferSize", 16)
+
+	viper.SetDefault("capture.persistFlows", true)
+
+	viper.SetDefault("session.window", "60s")
+
+	viper.SetDefault("stream.buffer.maxBytes", 1048576)
+
+	viper.SetDefault("stream.buffer.globalMaxBytes", 268435456)
+
+	viper.SetDefault("stream.buffer.policy", "spill")
+
+	viper.SetDefault("stream.buffer.pauseTimeout", "5s")
+
+	viper.SetDefault("stream.backpressure.segments", "dropOldest")
+
+	viper.SetDefault("stream.backpressure.packets", "block")
+
+	viper.SetDefault("stream.backpressure.ui", "dropOldest")
+
+	viper.SetDefault("stream.idleTimeout", "5m")
+
+	viper.SetDefault("stream.sweepInterval", "30s")
+
+	viper.SetDefault("capture.ring.window", "30s")
+
+	viper.SetDefault("capture.ring.megabytes", 16)
+
+	viper.SetDefault("agent.buffer", 65536)
+
+	viper.SetDefault("collect.listen", ":7071")
+
+	viper.SetDefault("proxy.listenHost", "0.0.0.0")
 
 	viper.SetDefault("protocol.xorKey", "0759694a941194858c8805cba09ecd583a365b1a6a16febddf9402f82196c8e99ef7bfbdcfcdb27a009f4022fc11f90c2e12fba7740a7d78401e2ca02d06cba8b97eefde49ea4e13161680f43dc29ad486d7942417f4d665bd3fdbe4e10f50f6ec7a9a0c273d2466d322689c9a520be0f9a50b25da80490dfd3e77d156a8b7f40f9be80f5247f56f832022db0f0bb14385c1cba40b0219dff08becdb6c6d66ad45be89147e2f8910b89360d860def6fe6e9bca06c1759533cfc0b2e0cca5ce12f6e5b5b426c5b2184f2a5d261b654df545c98414dc7c124b189cc724e73c64ffd63a2cee8c8149396cb7dcbd94e232f7dd0afc020164ec4c940ab156f5c9a934de0f3827bc81300f7b3825fee83e29ba5543bf6b9f1f8a4952187f8af888245c4fe1a830878e501f2fd10cb4fd0abcdc1285e252ee4a5838abffc63db960640ab450d54089179ad585cfec0d7e817fe3c3040122ec27ccfa3e21a654c8de00b6df279ff625340785bfa7a5a5e0830c3d5d2040af60a36456f305c41c7d3798c3e85a6e5885a49a6b6af4a37b619b09401e604b32d951a4fef95d4e4afb4ad47c330233d59dce5baa5a7cd8f805fa1f2b8c725750ae6c1989ca01fcfc299b61126863654626c45b50aa2bbeef9a790223752c2013fdd95a7623f10bb5b859f99f7ae606e9a53ab450bf165898b39a6e36ee8deb")
 
 	viper.SetDefault("protocol.xorLimit", 350)
 
+	viper.SetDefault("protocol.structs", "config/structs.yml")
+
+	viper.SetDefault("protocol.structsReload", "2s")
+
+	viper.SetDefault("protocol.xorRecovery.enabled", true)
+
+	viper.SetDefault("protocol.xorRecovery.minPackets", 3)
+
+	viper.SetDefault("protocol.maxPacketLength.client", 4096)
+
+	viper.SetDefault("protocol.maxPacketLength.server", 32767)
+
+	viper.SetDefault("protocol.order.hold", "50ms")
+
+	viper.SetDefault("protocol.order.workers", 4)
+
 	viper.SetDefault("protocol.log.client", true)
 
 	viper.SetDefault("protocol.log.server", true)
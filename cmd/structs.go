@@ -0,0 +1,22 @@
+// Package cmd used for various command configs
+package cmd
+
+import (
+	"github.com/shine-o/shine.engine.packet-sniffer/service"
+	"github.com/spf13/cobra"
+)
+
+// structsCmd represents the structs command
+var structsCmd = &cobra.Command{
+	Use:   "structs",
+	Short: "List the struct each operation code is unpacked with",
+	Long: `List the struct each operation code is unpacked with
+
+operation codes in protocol.commands use the struct with the same name, NC_ACT_SOMEONESTOP_CMD is unpacked with NcActSomeoneStopCmd,
+protocol.structs overrides them. Structs that no operation code uses are listed last, they need an entry in protocol.structs`,
+	Run: service.Structs,
+}
+
+func init() {
+	rootCmd.AddCommand(structsCmd)
+}
@@ -3,7 +3,7 @@ module github.com/shine-o/shine.engine.packet-sniffer
 go 1.13
 
 require (
-	github.com/google/gopacket v1.1.17
+	github.com/google/gopacket v1.1.18
 	github.com/google/logger v1.1.0
 	github.com/google/uuid v1.1.1
 	github.com/gorilla/websocket v1.4.2
@@ -18,6 +18,7 @@ require (
 	github.com/spf13/jwalterweatherman v1.1.0 // indirect
 	github.com/spf13/pflag v1.0.5 // indirect
 	github.com/spf13/viper v1.6.2
+	golang.org/x/net v0.0.0-20200324143707-d3edc9973b7e
 	gopkg.in/ini.v1 v1.55.0 // indirect
 	gopkg.in/restruct.v1 v1.0.0-20190323193435-3c2afb705f3c
 )
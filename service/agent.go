@@ -0,0 +1,225 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"runtime"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// agentPacket raw frame read by the agent
+// the interface it was read on is in ci.InterfaceIndex
+type agentPacket struct {
+	data []byte
+	ci   gopacket.CaptureInfo
+}
+
+// agentQueue packets waiting to be sent to the collector
+// when it's full, the oldest packets are dropped
+type agentQueue struct {
+	packets chan agentPacket
+	dropped uint64
+}
+
+func (q *agentQueue) push(p agentPacket) {
+	for {
+		select {
+		case q.packets <- p:
+			return
+		default:
+			select {
+			case <-q.packets:
+				atomic.AddUint64(&q.dropped, 1)
+			default:
+			}
+		}
+	}
+}
+
+// collectorLink connection from the agent to the collector
+type collectorLink struct {
+	name  string
+	addr  string
+	intfs []pcapgo.NgInterface
+	queue *agentQueue
+	// packet that was being sent when the connection dropped
+	pending *agentPacket
+}
+
+// Agent captures packets and streams them to a collector, which decodes them
+func Agent(cmd *cobra.Command, args []string) {
+	runtime.GOMAXPROCS(runtime.NumCPU())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	config(ctx)
+
+	name := viper.GetString("agent.name")
+	if name == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			log.Fatal(err)
+		}
+		name = hostname
+	}
+
+	addr := viper.GetString("agent.collector")
+	if addr == "" {
+		log.Fatal("required config parameter is missing: agent.collector")
+	}
+
+	queue := &agentQueue{
+		packets: make(chan agentPacket, viper.GetInt("agent.buffer")),
+	}
+
+	link := &collectorLink{
+		name:  name,
+		addr:  addr,
+		queue: queue,
+	}
+
+	for i, iface := range ifaces {
+		handle, err := openCaptureHandle(iface)
+		if err != nil {
+			log.Fatalf("[%v] %v", iface, err)
+		}
+		live.add(iface, handle)
+		link.intfs = append(link.intfs, pcapgo.NgInterface{
+			Name:       iface,
+			LinkType:   handle.LinkType(),
+			SnapLength: uint32(snaplen),
+		})
+		log.Infof("agent %v capturing on %v, link type %v", name, iface, handle.LinkType())
+		go agentReadPackets(ctx, i, iface, handle, queue)
+	}
+
+	go link.stream(ctx)
+
+	c := make(chan os.Signal, 2)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM) // subscribe to system signals
+	<-c
+	cancel()
+	log.Infof("capture stats: %+v, dropped while the collector was unreachable: %v", live.stats(), atomic.LoadUint64(&queue.dropped))
+}
+
+// read raw frames from an interface into the queue
+func agentReadPackets(ctx context.Context, index int, iface string, handle captureHandle, queue *agentQueue) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		data, ci, err := handle.ReadPacketData()
+		if err == io.EOF {
+			log.Warningf("[%v] no more packets can be read", iface)
+			return
+		}
+		if err != nil {
+			if isTemporary(err) {
+				continue
+			}
+			log.Errorf("[%v] error reading packet: %v", iface, err)
+			return
+		}
+
+		ci.InterfaceIndex = index
+		queue.push(agentPacket{
+			data: data,
+			ci:   ci,
+		})
+	}
+}
+
+// keep a connection to the collector, reconnecting when it drops
+// packets captured in the meantime wait in the queue
+func (cl *collectorLink) stream(ctx context.Context) {
+	backoff := time.Second
+	for {
+		conn, err := net.DialTimeout("tcp", cl.addr, 10*time.Second)
+		if err != nil {
+			log.Warningf("could not connect to collector %v: %v, retrying in %v", cl.addr, err, backoff)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			if backoff < 30*time.Second {
+				backoff *= 2
+			}
+			continue
+		}
+
+		backoff = time.Second
+		log.Infof("connected to collector %v", cl.addr)
+
+		err = cl.send(ctx, conn)
+		if cErr := conn.Close(); cErr != nil {
+			log.Error(cErr)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		log.Warningf("lost connection to collector %v: %v, reconnecting", cl.addr, err)
+	}
+}
+
+// every connection starts with the agent name on its own line, followed by a pcapng stream
+func (cl *collectorLink) send(ctx context.Context, conn net.Conn) error {
+	if _, err := fmt.Fprintf(conn, "%v\n", cl.name); err != nil {
+		return err
+	}
+
+	w, err := pcapgo.NewNgWriterInterface(conn, cl.intfs[0], pcapgo.NgWriterOptions{
+		SectionInfo: pcapgo.NgSectionInfo{
+			Application: "shine packet sniffer agent",
+			Comment:     cl.name,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, intf := range cl.intfs[1:] {
+		if _, err := w.AddInterface(intf); err != nil {
+			return err
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	for {
+		if cl.pending == nil {
+			select {
+			case <-ctx.Done():
+				return w.Flush()
+			case p := <-cl.queue.packets:
+				cl.pending = &p
+			}
+		}
+
+		if err := w.WritePacket(cl.pending.ci, cl.pending.data); err != nil {
+			return err
+		}
+		cl.pending = nil
+
+		// flush once the queue is drained, so packets are not held back when traffic is low
+		if len(cl.queue.packets) == 0 {
+			if err := w.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+}
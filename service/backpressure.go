@@ -0,0 +1,289 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/spf13/viper"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// backpressurePolicy what a pipeline stage does when the next one can't keep up
+type backpressurePolicy string
+
+const (
+	// wait for room, slowing down every stage before it
+	block backpressurePolicy = "block"
+	// discard what was queued first to make room
+	dropOldest backpressurePolicy = "dropOldest"
+	// discard what is being queued
+	dropNewest backpressurePolicy = "dropNewest"
+)
+
+// backpressureSettings stream.backpressure, the policy of each stage of the pipeline
+type backpressureSettings struct {
+	// assembler to decoders, dropped bytes are reported to the decoder as a gap
+	segments backpressurePolicy
+	// decoders to the packet logger
+	packets backpressurePolicy
+	// packet logger to the websocket clients
+	ui backpressurePolicy
+}
+
+// dropCounters what was discarded by the backpressure policies
+type dropCounters struct {
+	Segments     uint64 `json:"segments"`
+	SegmentBytes uint64 `json:"segmentBytes"`
+	Packets      uint64 `json:"packets"`
+	UI           uint64 `json:"ui"`
+}
+
+// pipelineStats drops of the whole pipeline, with the counters of the capture backend
+type pipelineStats struct {
+	Kernel map[string]captureStats `json:"kernel"`
+	Total  dropCounters            `json:"total"`
+	Flows  []flowDrops             `json:"flows,omitempty"`
+}
+
+type flowDrops struct {
+	FlowID string `json:"flow_id"`
+	dropCounters
+}
+
+const uiQueueLength = 512
+
+var (
+	backpressure backpressureSettings
+	// every stream together, including the ones that are closed
+	pipelineDrops = &dropCounters{}
+	uiQueue       = make(chan uiPacket, uiQueueLength)
+	uiSender      sync.Once
+)
+
+// uiPacket a packet waiting to be written to the websocket clients
+type uiPacket struct {
+	ss *shineStream
+	pv PacketView
+}
+
+func loadBackpressureConfig() error {
+	backpressure = backpressureSettings{
+		segments: backpressurePolicy(viper.GetString("stream.backpressure.segments")),
+		packets:  backpressurePolicy(viper.GetString("stream.backpressure.packets")),
+		ui:       backpressurePolicy(viper.GetString("stream.backpressure.ui")),
+	}
+	for stage, p := range map[string]backpressurePolicy{
+		"segments": backpressure.segments,
+		"packets":  backpressure.packets,
+		"ui":       backpressure.ui,
+	} {
+		switch p {
+		case block, dropOldest, dropNewest:
+		default:
+			return fmt.Errorf("unknown stream.backpressure.%v policy %v", stage, p)
+		}
+	}
+	return nil
+}
+
+func (dc *dropCounters) droppedSegment(seg shineSegment) {
+	atomic.AddUint64(&dc.Segments, 1)
+	atomic.AddUint64(&dc.SegmentBytes, uint64(len(seg.data)))
+}
+
+func (dc *dropCounters) snapshot() dropCounters {
+	return dropCounters{
+		Segments:     atomic.LoadUint64(&dc.Segments),
+		SegmentBytes: atomic.LoadUint64(&dc.SegmentBytes),
+		Packets:      atomic.LoadUint64(&dc.Packets),
+		UI:           atomic.LoadUint64(&dc.UI),
+	}
+}
+
+func (dc dropCounters) any() bool {
+	return dc.Segments > 0 || dc.Packets > 0 || dc.UI > 0
+}
+
+// bytes of the stream a dropped segment takes with it, -1 if it's unknown how many
+func addGap(gap, lost int) int {
+	if gap < 0 || lost < 0 {
+		return -1
+	}
+	return gap + lost
+}
+
+// queue a segment for the decoder of its direction, as stream.backpressure.segments says
+// the caller holds ss.sendMu[d], so segments of a direction are queued by one goroutine at a time
+func (ss *shineStream) queueSegment(segments chan shineSegment, seg shineSegment, d int) {
+	seg.index = ss.queued[d]
+	ss.queued[d]++
+
+	if backpressure.segments == block {
+		segments <- seg
+		return
+	}
+
+	for {
+		select {
+		case segments <- seg:
+			return
+		default:
+		}
+
+		if backpressure.segments == dropNewest {
+			ss.dropSegment(seg, d)
+			return
+		}
+
+		// the decoder may take it first, then there is room for the new one
+		select {
+		case old := <-segments:
+			ss.dropSegment(old, d)
+		default:
+		}
+	}
+}
+
+func (ss *shineStream) dropSegment(seg shineSegment, d int) {
+	ss.drops.droppedSegment(seg)
+	pipelineDrops.droppedSegment(seg)
+
+	ss.dropMu.Lock()
+	if ss.dropped[d] == nil {
+		ss.dropped[d] = make(map[uint64]int)
+	}
+	ss.dropped[d][seg.index] = addGap(seg.gap, len(seg.data))
+	ss.dropMu.Unlock()
+}
+
+// add the bytes of the segments dropped before this one to its gap, next is the index the decoder expects
+func (ss *shineStream) catchUp(seg *shineSegment, next *uint64, d int) {
+	if seg.index == *next {
+		*next++
+		return
+	}
+
+	var n int
+	lost := 0
+	ss.dropMu.Lock()
+	for i := *next; i < seg.index; i++ {
+		if l, ok := ss.dropped[d][i]; ok {
+			lost = addGap(lost, l)
+			delete(ss.dropped[d], i)
+			n++
+		}
+	}
+	ss.dropMu.Unlock()
+	*next = seg.index + 1
+
+	if n == 0 {
+		return
+	}
+	log.Warningf("[%v %v] %v segments were dropped before this one, the decoder couldn't keep up", ss.net, ss.transport, n)
+	seg.gap = addGap(seg.gap, lost)
+}
+
+// queue a decoded packet for the logger, as stream.backpressure.packets says
+// both decoders of a stream send to it
+func (ss *shineStream) queuePacket(dp decodedPacket) {
+	if backpressure.packets == block {
+		ss.packets <- dp
+		return
+	}
+
+	for {
+		select {
+		case ss.packets <- dp:
+			return
+		default:
+		}
+
+		if backpressure.packets == dropNewest {
+			ss.dropPacket()
+			return
+		}
+
+		select {
+		case <-ss.packets:
+			ss.dropPacket()
+		default:
+		}
+	}
+}
+
+func (ss *shineStream) dropPacket() {
+	atomic.AddUint64(&ss.drops.Packets, 1)
+	atomic.AddUint64(&pipelineDrops.Packets, 1)
+}
+
+// queue a packet for the websocket clients, as stream.backpressure.ui says
+// a slow client slows down the queue instead of the logger
+func (ss *shineStream) queueUI(pv PacketView) {
+	uiSender.Do(func() {
+		go func() {
+			for p := range uiQueue {
+				sendPacketToUI(p.pv)
+			}
+		}()
+	})
+
+	p := uiPacket{
+		ss: ss,
+		pv: pv,
+	}
+
+	if backpressure.ui == block {
+		uiQueue <- p
+		return
+	}
+
+	for {
+		select {
+		case uiQueue <- p:
+			return
+		default:
+		}
+
+		if backpressure.ui == dropNewest {
+			ss.dropUI()
+			return
+		}
+
+		select {
+		case old := <-uiQueue:
+			old.ss.dropUI()
+		default:
+		}
+	}
+}
+
+func (ss *shineStream) dropUI() {
+	atomic.AddUint64(&ss.drops.UI, 1)
+	atomic.AddUint64(&pipelineDrops.UI, 1)
+}
+
+// serve the drops of every stage of the pipeline and of the capture backend as json
+func capturePipelineHandler(w http.ResponseWriter, r *http.Request) {
+	stats := pipelineStats{
+		Kernel: live.stats(),
+		Total:  pipelineDrops.snapshot(),
+	}
+	for _, ss := range activeStreams.find("") {
+		if dc := ss.drops.snapshot(); dc.any() {
+			stats.Flows = append(stats.Flows, flowDrops{
+				FlowID:       ss.flowID,
+				dropCounters: dc,
+			})
+		}
+	}
+	sort.Slice(stats.Flows, func(i, j int) bool {
+		return stats.Flows[i].FlowID < stats.Flows[j].FlowID
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(stats); err != nil {
+		log.Error(err)
+	}
+}
@@ -0,0 +1,155 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestQueueSegmentGaps(t *testing.T) {
+	segment := func(n, gap int) shineSegment {
+		return shineSegment{
+			data: make([]byte, n),
+			gap:  gap,
+		}
+	}
+
+	tests := []struct {
+		name   string
+		policy backpressurePolicy
+		// queued while the decoder is busy, then the decoder takes what's left and the last segment
+		segments []shineSegment
+		last     shineSegment
+		// gap of every segment the decoder gets
+		wantGaps  []int
+		wantDrops dropCounters
+	}{
+		{
+			name:      "block",
+			policy:    block,
+			segments:  []shineSegment{segment(10, 0), segment(20, 0)},
+			last:      segment(30, 0),
+			wantGaps:  []int{0, 0, 0},
+			wantDrops: dropCounters{},
+		},
+		{
+			name:      "drop newest",
+			policy:    dropNewest,
+			segments:  []shineSegment{segment(10, 0), segment(20, 0), segment(30, 0), segment(40, 0)},
+			last:      segment(50, 0),
+			wantGaps:  []int{0, 0, 70},
+			wantDrops: dropCounters{Segments: 2, SegmentBytes: 70},
+		},
+		{
+			name:      "drop oldest",
+			policy:    dropOldest,
+			segments:  []shineSegment{segment(10, 0), segment(20, 0), segment(30, 0), segment(40, 0)},
+			last:      segment(50, 0),
+			wantGaps:  []int{30, 0, 0},
+			wantDrops: dropCounters{Segments: 2, SegmentBytes: 30},
+		},
+		{
+			name:      "gaps of dropped segments are added",
+			policy:    dropNewest,
+			segments:  []shineSegment{segment(10, 0), segment(20, 0), segment(30, 5), segment(40, 0)},
+			last:      segment(50, 2),
+			wantGaps:  []int{0, 0, 77},
+			wantDrops: dropCounters{Segments: 2, SegmentBytes: 70},
+		},
+		{
+			name:      "unknown gap of a dropped segment",
+			policy:    dropOldest,
+			segments:  []shineSegment{segment(10, -1), segment(20, 0), segment(30, 0), segment(40, 0)},
+			last:      segment(50, 0),
+			wantGaps:  []int{-1, 0, 0},
+			wantDrops: dropCounters{Segments: 2, SegmentBytes: 30},
+		},
+	}
+
+	defer func(b backpressureSettings) {
+		backpressure = b
+	}(backpressure)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backpressure.segments = tt.policy
+			ss := &shineStream{
+				drops: &dropCounters{},
+			}
+			segments := make(chan shineSegment, 2)
+			if tt.policy == block {
+				segments = make(chan shineSegment, len(tt.segments)+1)
+			}
+
+			for _, seg := range tt.segments {
+				ss.queueSegment(segments, seg, 0)
+			}
+
+			var (
+				gaps []int
+				next uint64
+			)
+			take := func() {
+				for len(segments) > 0 {
+					seg := <-segments
+					ss.catchUp(&seg, &next, 0)
+					gaps = append(gaps, seg.gap)
+				}
+			}
+			take()
+			ss.queueSegment(segments, tt.last, 0)
+			take()
+
+			if !reflect.DeepEqual(gaps, tt.wantGaps) {
+				t.Errorf("gaps %v, want %v", gaps, tt.wantGaps)
+			}
+			if got := ss.drops.snapshot(); got != tt.wantDrops {
+				t.Errorf("drops %+v, want %+v", got, tt.wantDrops)
+			}
+			if len(ss.dropped[0]) != 0 {
+				t.Errorf("dropped segments left after catching up: %v", ss.dropped[0])
+			}
+		})
+	}
+}
+
+// a decoder annotating frames while the assembler waits for room in its channel
+func TestPushBlockDoesNotStallDecoder(t *testing.T) {
+	defer func(b backpressureSettings) {
+		backpressure = b
+	}(backpressure)
+	backpressure.segments = block
+
+	ss := &shineStream{
+		client: make(chan shineSegment, 1),
+		server: make(chan shineSegment, 1),
+		drops:  &dropCounters{},
+	}
+	ss.push(shineSegment{data: []byte{1}}, true)
+
+	pushed := make(chan struct{})
+	go func() {
+		ss.push(shineSegment{data: []byte{2}}, true)
+		close(pushed)
+	}()
+	// give the push time to block on the full channel
+	time.Sleep(20 * time.Millisecond)
+
+	decoded := make(chan struct{})
+	go func() {
+		// the server direction and the decoders can go on
+		ss.push(shineSegment{data: []byte{3}}, false)
+		ss.annotateFrame(1, nil, "outbound")
+		<-ss.client
+		close(decoded)
+	}()
+
+	for _, c := range []chan struct{}{decoded, pushed} {
+		select {
+		case <-c:
+		case <-time.After(time.Second):
+			t.Fatal("stream is deadlocked")
+		}
+	}
+	ss.close()
+}
@@ -0,0 +1,220 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/google/gopacket/reassembly"
+	"net"
+	"testing"
+	"time"
+)
+
+const benchServerPort = 9010
+
+type nopStreamFactory struct{}
+
+type nopStream struct{}
+
+func (nopStreamFactory) New(net, transport gopacket.Flow, tcp *layers.TCP, ac reassembly.AssemblerContext) reassembly.Stream {
+	return nopStream{}
+}
+
+func (nopStream) Accept(tcp *layers.TCP, ci gopacket.CaptureInfo, dir reassembly.TCPFlowDirection, nextSeq reassembly.Sequence, start *bool, ac reassembly.AssemblerContext) bool {
+	return true
+}
+
+func (nopStream) ReassembledSG(sg reassembly.ScatterGather, ac reassembly.AssemblerContext) {
+	length, _ := sg.Lengths()
+	_ = sg.Fetch(length)
+}
+
+func (nopStream) ReassemblyComplete(ac reassembly.AssemblerContext) bool {
+	return true
+}
+
+const (
+	benchPackets = 100000
+	benchFlows   = 100
+)
+
+// only capture decoding and reassembly are measured, shine packets are not decoded
+// go test -bench Decoder ./service
+func BenchmarkPacketDecoder(b *testing.B) {
+	benchmarkDecoder(b, func(r *pcapgo.Reader, a *reassembly.Assembler) {
+		ps := gopacket.NewPacketSource(r, r.LinkType())
+		for packet := range ps.Packets() {
+			assemblePacket(a, packet, Context{})
+		}
+	})
+}
+
+func BenchmarkParserDecoder(b *testing.B) {
+	benchmarkDecoder(b, func(r *pcapgo.Reader, a *reassembly.Assembler) {
+		err := parsePackets(context.Background(), r, r.LinkType(), func(netFlow gopacket.Flow, tcp *layers.TCP, ci gopacket.CaptureInfo, data []byte) {
+			assembleTCP(a, netFlow, tcp, Context{
+				ci: ci,
+			})
+		})
+		if err != nil {
+			b.Error(err)
+		}
+	})
+}
+
+func benchmarkDecoder(b *testing.B, run func(r *pcapgo.Reader, a *reassembly.Assembler)) {
+	capture, err := syntheticCapture(benchPackets, benchFlows)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	// the synthetic capture only has traffic to a single server port
+	defer func(p serverPorts) {
+		ports = p
+	}(ports)
+	ports = serverPorts{
+		start: benchServerPort,
+		end:   benchServerPort,
+	}
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(capture)))
+	b.ResetTimer()
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		r, err := pcapgo.NewReader(bytes.NewReader(capture))
+		if err != nil {
+			b.Fatal(err)
+		}
+		a := reassembly.NewAssembler(reassembly.NewStreamPool(nopStreamFactory{}))
+		run(r, a)
+		a.FlushAll()
+	}
+	b.StopTimer()
+
+	total := float64(b.N * benchPackets)
+	b.ReportMetric(total/time.Since(start).Seconds(), "packets/s")
+}
+
+// build an ethernet pcap with the given amount of tcp packets, spread over flows to the same server
+// each packet carries a small shine packet, directions alternate between client and server
+func syntheticCapture(packets, flows int) ([]byte, error) {
+	if flows <= 0 || packets < flows*3 {
+		return nil, fmt.Errorf("need at least 3 packets per flow for the handshake, got %v packets for %v flows", packets, flows)
+	}
+
+	var buf bytes.Buffer
+	w := pcapgo.NewWriter(&buf)
+	if err := w.WriteFileHeader(65536, layers.LinkTypeEthernet); err != nil {
+		return nil, err
+	}
+
+	var (
+		clientIP  = net.IP{10, 0, 0, 2}
+		serverIP  = net.IP{10, 0, 0, 1}
+		clientMAC = net.HardwareAddr{0x02, 0, 0, 0, 0, 0x02}
+		serverMAC = net.HardwareAddr{0x02, 0, 0, 0, 0, 0x01}
+		ts        = time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+		opts      = gopacket.SerializeOptions{
+			FixLengths:       true,
+			ComputeChecksums: true,
+		}
+	)
+
+	// 1 byte length, 2 bytes operation code, 16 bytes of data
+	shinePacket := make([]byte, 19)
+	shinePacket[0] = 18
+	shinePacket[1], shinePacket[2] = 0x07, 0x08
+
+	type flow struct {
+		port                 layers.TCPPort
+		clientSeq, serverSeq uint32
+	}
+
+	write := func(f *flow, fromClient bool, syn, ack bool, payload []byte) error {
+		eth := layers.Ethernet{
+			SrcMAC:       clientMAC,
+			DstMAC:       serverMAC,
+			EthernetType: layers.EthernetTypeIPv4,
+		}
+		ip := layers.IPv4{
+			Version:  4,
+			TTL:      64,
+			Protocol: layers.IPProtocolTCP,
+			SrcIP:    clientIP,
+			DstIP:    serverIP,
+		}
+		tcp := layers.TCP{
+			SrcPort: f.port,
+			DstPort: benchServerPort,
+			Seq:     f.clientSeq,
+			Ack:     f.serverSeq,
+			SYN:     syn,
+			ACK:     ack,
+			Window:  65535,
+		}
+		if !fromClient {
+			eth.SrcMAC, eth.DstMAC = serverMAC, clientMAC
+			ip.SrcIP, ip.DstIP = serverIP, clientIP
+			tcp.SrcPort, tcp.DstPort = benchServerPort, f.port
+			tcp.Seq, tcp.Ack = f.serverSeq, f.clientSeq
+		}
+		if err := tcp.SetNetworkLayerForChecksum(&ip); err != nil {
+			return err
+		}
+
+		sb := gopacket.NewSerializeBuffer()
+		if err := gopacket.SerializeLayers(sb, opts, &eth, &ip, &tcp, gopacket.Payload(payload)); err != nil {
+			return err
+		}
+
+		ts = ts.Add(time.Millisecond)
+		data := sb.Bytes()
+		ci := gopacket.CaptureInfo{
+			Timestamp:     ts,
+			CaptureLength: len(data),
+			Length:        len(data),
+		}
+
+		advance := uint32(len(payload))
+		if syn {
+			advance = 1
+		}
+		if fromClient {
+			f.clientSeq += advance
+		} else {
+			f.serverSeq += advance
+		}
+		return w.WritePacket(ci, data)
+	}
+
+	fs := make([]*flow, flows)
+	for i := range fs {
+		f := &flow{
+			port:      layers.TCPPort(50000 + i),
+			clientSeq: 1000,
+			serverSeq: 5000,
+		}
+		fs[i] = f
+		if err := write(f, true, true, false, nil); err != nil {
+			return nil, err
+		}
+		if err := write(f, false, true, true, nil); err != nil {
+			return nil, err
+		}
+		if err := write(f, true, false, true, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	for i := 0; i < packets-flows*3; i++ {
+		if err := write(fs[i%flows], i%2 == 0, false, true, shinePacket); err != nil {
+			return nil, err
+		}
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,316 @@
+package service
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"github.com/spf13/viper"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// bufferSettings stream.buffer, limits on the bytes decoders hold while they wait for complete packets
+type bufferSettings struct {
+	streamMax int
+	globalMax int64
+	// drop, spill or pause
+	policy       string
+	pauseTimeout time.Duration
+}
+
+// admission what happens to a segment that is offered to a buffer
+type admission int
+
+const (
+	admitted admission = iota
+	// written to disk, it's read back once there is room
+	spilled
+	// the stream went over its limit and won't be decoded anymore
+	dropped
+)
+
+// a whole packet must always fit, its length is at most 0xFFFF plus a 3 byte header
+const minStreamBuffer = 65538
+
+var (
+	bufferLimits bufferSettings
+	// bytes held by every stream buffer
+	bufferedBytes int64
+)
+
+func loadBufferConfig() error {
+	bufferLimits = bufferSettings{
+		streamMax:    viper.GetInt("stream.buffer.maxBytes"),
+		globalMax:    viper.GetInt64("stream.buffer.globalMaxBytes"),
+		policy:       viper.GetString("stream.buffer.policy"),
+		pauseTimeout: viper.GetDuration("stream.buffer.pauseTimeout"),
+	}
+	switch bufferLimits.policy {
+	case "drop", "spill", "pause":
+	default:
+		return fmt.Errorf("unknown stream.buffer.policy %v", bufferLimits.policy)
+	}
+	if bufferLimits.streamMax < minStreamBuffer {
+		log.Warningf("stream.buffer.maxBytes %v can't hold every packet, using %v", bufferLimits.streamMax, minStreamBuffer)
+		bufferLimits.streamMax = minStreamBuffer
+	}
+	return nil
+}
+
+// streamBuffer data of one direction of a stream that wasn't decoded yet
+// consumed bytes are released as packets are decoded, segments that go over the limits are handled by the policy
+type streamBuffer struct {
+	data   []byte
+	offset int
+	// stream position of data[0]
+	base int64
+	// segments waiting on disk, oldest first
+	spill                 *os.File
+	spillRead, spillWrite int64
+	spillSegments         int
+	// read by the status endpoint
+	memory, spilled int64
+	state           atomic.Value
+}
+
+func newStreamBuffer() *streamBuffer {
+	b := &streamBuffer{}
+	b.state.Store("decoding")
+	return b
+}
+
+// bytes not decoded yet
+func (b *streamBuffer) pending() int {
+	return len(b.data) - b.offset
+}
+
+// a buffer that holds nothing can always take a segment, so a stream isn't starved by the others
+func (b *streamBuffer) over(n int) bool {
+	if b.pending()+n > bufferLimits.streamMax {
+		return true
+	}
+	return b.pending() > 0 && atomic.LoadInt64(&bufferedBytes)+int64(n) > bufferLimits.globalMax
+}
+
+// decide if a segment can be appended now
+func (b *streamBuffer) admit(ctx context.Context, seg shineSegment) admission {
+	// segments already on disk go first
+	if b.spillSegments > 0 {
+		return b.spillSegment(seg)
+	}
+	if !b.over(len(seg.data)) {
+		return admitted
+	}
+
+	switch bufferLimits.policy {
+	case "spill":
+		return b.spillSegment(seg)
+	case "pause":
+		// the buffers of other streams may drain meanwhile, this stream's own can't without more data
+		b.state.Store("paused")
+		deadline := time.Now().Add(bufferLimits.pauseTimeout)
+		for b.over(len(seg.data)) && time.Now().Before(deadline) {
+			select {
+			case <-ctx.Done():
+				return dropped
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+		if !b.over(len(seg.data)) {
+			b.state.Store("decoding")
+			return admitted
+		}
+	}
+	b.state.Store("dropped")
+	return dropped
+}
+
+// append segment data, after admit
+func (b *streamBuffer) append(data []byte) {
+	b.data = append(b.data, data...)
+	b.account()
+}
+
+// release consumed bytes
+func (b *streamBuffer) compact() {
+	if b.offset == 0 {
+		return
+	}
+	n := copy(b.data, b.data[b.offset:])
+	b.base += int64(b.offset)
+	b.offset = 0
+	if cap(b.data) > minStreamBuffer && n < cap(b.data)/4 {
+		// a large packet or a backlog grew it, it doesn't need to stay that large
+		data := make([]byte, n)
+		copy(data, b.data)
+		b.data = data
+	} else {
+		b.data = b.data[:n]
+	}
+	b.account()
+}
+
+// throw away what's buffered and lost bytes that were never received, the stream continues after them
+func (b *streamBuffer) skip(lost int) {
+	b.base += int64(len(b.data) + lost)
+	b.data = b.data[:0]
+	b.offset = 0
+	b.account()
+}
+
+// update the global count with the memory held now
+func (b *streamBuffer) account() {
+	memory := int64(len(b.data))
+	atomic.AddInt64(&bufferedBytes, memory-atomic.SwapInt64(&b.memory, memory))
+}
+
+// segment record on disk: gap, frame, seen and the data length, followed by the data
+const spillHeaderLen = 8 + 8 + 8 + 4
+
+func (b *streamBuffer) spillSegment(seg shineSegment) admission {
+	if b.spill == nil {
+		dir, err := filepath.Abs("output/spill")
+		if err == nil {
+			err = os.MkdirAll(dir, 0700)
+		}
+		if err == nil {
+			b.spill, err = ioutil.TempFile(dir, "stream-*.spill")
+		}
+		if err != nil {
+			log.Errorf("could not spill stream buffer: %v", err)
+			b.state.Store("dropped")
+			return dropped
+		}
+	}
+
+	record := make([]byte, spillHeaderLen, spillHeaderLen+len(seg.data))
+	binary.LittleEndian.PutUint64(record[0:], uint64(int64(seg.gap)))
+	binary.LittleEndian.PutUint64(record[8:], seg.frame)
+	binary.LittleEndian.PutUint64(record[16:], uint64(seg.seen.UnixNano()))
+	binary.LittleEndian.PutUint32(record[24:], uint32(len(seg.data)))
+	record = append(record, seg.data...)
+
+	if _, err := b.spill.WriteAt(record, b.spillWrite); err != nil {
+		log.Errorf("could not spill stream buffer: %v", err)
+		b.state.Store("dropped")
+		return dropped
+	}
+	b.spillWrite += int64(len(record))
+	b.spillSegments++
+	atomic.AddInt64(&b.spilled, int64(len(seg.data)))
+	b.state.Store("spilling")
+	return spilled
+}
+
+// next segment on disk, if there is room for it in memory or all is set
+func (b *streamBuffer) unspill(all bool) (shineSegment, bool) {
+	if b.spillSegments == 0 {
+		return shineSegment{}, false
+	}
+
+	header := make([]byte, spillHeaderLen)
+	if _, err := b.spill.ReadAt(header, b.spillRead); err != nil {
+		log.Errorf("could not read spilled stream buffer: %v", err)
+		return shineSegment{}, false
+	}
+	n := int(binary.LittleEndian.Uint32(header[24:]))
+	if !all && b.over(n) {
+		return shineSegment{}, false
+	}
+
+	seg := shineSegment{
+		gap:   int(int64(binary.LittleEndian.Uint64(header[0:]))),
+		frame: binary.LittleEndian.Uint64(header[8:]),
+		seen:  time.Unix(0, int64(binary.LittleEndian.Uint64(header[16:]))),
+		data:  make([]byte, n),
+	}
+	if _, err := b.spill.ReadAt(seg.data, b.spillRead+spillHeaderLen); err != nil && err != io.EOF {
+		log.Errorf("could not read spilled stream buffer: %v", err)
+		return shineSegment{}, false
+	}
+
+	b.spillRead += int64(spillHeaderLen + n)
+	b.spillSegments--
+	atomic.AddInt64(&b.spilled, -int64(n))
+	if b.spillSegments == 0 {
+		// start over, so the file doesn't keep growing
+		b.spillRead, b.spillWrite = 0, 0
+		if err := b.spill.Truncate(0); err != nil {
+			log.Error(err)
+		}
+		b.state.Store("decoding")
+	}
+	return seg, true
+}
+
+// give back the memory and remove the spill file, once the decoder is done
+func (b *streamBuffer) release() {
+	b.data = nil
+	b.offset = 0
+	b.account()
+	if b.spill != nil {
+		b.spill.Close()
+		if err := os.Remove(b.spill.Name()); err != nil {
+			log.Error(err)
+		}
+		atomic.StoreInt64(&b.spilled, 0)
+	}
+}
+
+// bufferStatus how a stream's buffers are exposed by /capture/buffers
+type bufferStatus struct {
+	FlowID        string `json:"flow_id"`
+	ConnectionKey string `json:"connection_key"`
+	Client        int64  `json:"client_bytes"`
+	Server        int64  `json:"server_bytes"`
+	ClientSpilled int64  `json:"client_spilled_bytes"`
+	ServerSpilled int64  `json:"server_spilled_bytes"`
+	ClientState   string `json:"client_state"`
+	ServerState   string `json:"server_state"`
+}
+
+type buffersStatus struct {
+	Total     int64          `json:"total_bytes"`
+	StreamMax int            `json:"stream_max_bytes"`
+	GlobalMax int64          `json:"global_max_bytes"`
+	Policy    string         `json:"policy"`
+	Streams   []bufferStatus `json:"streams"`
+}
+
+func captureBuffersHandler(w http.ResponseWriter, r *http.Request) {
+	status := buffersStatus{
+		Total:     atomic.LoadInt64(&bufferedBytes),
+		StreamMax: bufferLimits.streamMax,
+		GlobalMax: bufferLimits.globalMax,
+		Policy:    bufferLimits.policy,
+	}
+	for _, ss := range activeStreams.find("") {
+		status.Streams = append(status.Streams, bufferStatus{
+			FlowID:        ss.flowID,
+			ConnectionKey: connectionKey(ss.net, ss.transport),
+			Client:        atomic.LoadInt64(&ss.clientBuffer.memory),
+			Server:        atomic.LoadInt64(&ss.serverBuffer.memory),
+			ClientSpilled: atomic.LoadInt64(&ss.clientBuffer.spilled),
+			ServerSpilled: atomic.LoadInt64(&ss.serverBuffer.spilled),
+			ClientState:   ss.clientBuffer.state.Load().(string),
+			ServerState:   ss.serverBuffer.state.Load().(string),
+		})
+	}
+	// largest first
+	sort.Slice(status.Streams, func(i, j int) bool {
+		a, b := status.Streams[i], status.Streams[j]
+		return a.Client+a.Server+a.ClientSpilled+a.ServerSpilled > b.Client+b.Server+b.ClientSpilled+b.ServerSpilled
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		log.Error(err)
+	}
+}
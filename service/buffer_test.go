@@ -0,0 +1,136 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStreamBufferSpill(t *testing.T) {
+	segment := func(n, gap int, frame uint64) shineSegment {
+		return shineSegment{
+			data:  bytes.Repeat([]byte{byte(frame)}, n),
+			gap:   gap,
+			frame: frame,
+			seen:  time.Unix(1591012800, int64(frame)),
+		}
+	}
+
+	tests := []struct {
+		name   string
+		policy string
+		// bytes the buffer holds before the segments are offered
+		held     int
+		segments []shineSegment
+		want     []admission
+		// bytes the decoder consumes before the segments are read back from disk
+		consumed int
+		// segments that fit in memory after that
+		wantRoom int
+	}{
+		{
+			name:     "fits",
+			policy:   "spill",
+			segments: []shineSegment{segment(100, 0, 1)},
+			want:     []admission{admitted},
+		},
+		{
+			name:     "over the limit",
+			policy:   "spill",
+			held:     minStreamBuffer - 10,
+			segments: []shineSegment{segment(100, 3, 1), segment(5, -1, 2), segment(200, 0, 3)},
+			// the small segment would fit, but it must stay behind the ones on disk
+			want: []admission{spilled, spilled, spilled},
+		},
+		{
+			name:     "room for the first segment on disk",
+			policy:   "spill",
+			held:     minStreamBuffer - 150,
+			segments: []shineSegment{segment(100, 0, 1), segment(100, 0, 2), segment(100, 0, 3)},
+			want:     []admission{admitted, spilled, spilled},
+			consumed: 100,
+			wantRoom: 1,
+		},
+		{
+			name:     "drop policy",
+			policy:   "drop",
+			held:     minStreamBuffer - 10,
+			segments: []shineSegment{segment(100, 0, 1)},
+			want:     []admission{dropped},
+		},
+	}
+
+	defer func(limits bufferSettings) {
+		bufferLimits = limits
+	}(bufferLimits)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bufferLimits = bufferSettings{
+				streamMax: minStreamBuffer,
+				globalMax: 1 << 30,
+				policy:    tt.policy,
+			}
+			b := newStreamBuffer()
+			defer b.release()
+			b.append(make([]byte, tt.held))
+
+			var onDisk []shineSegment
+			for i, seg := range tt.segments {
+				got := b.admit(context.Background(), seg)
+				if got != tt.want[i] {
+					t.Fatalf("segment %v: got %v, want %v", i, got, tt.want[i])
+				}
+				switch got {
+				case admitted:
+					b.append(seg.data)
+				case spilled:
+					onDisk = append(onDisk, seg)
+				}
+			}
+
+			b.offset += tt.consumed
+			b.compact()
+			var read []shineSegment
+			for {
+				seg, ok := b.unspill(false)
+				if !ok {
+					break
+				}
+				b.append(seg.data)
+				read = append(read, seg)
+			}
+			if len(read) != tt.wantRoom {
+				t.Fatalf("%v segments read back, want %v to fit", len(read), tt.wantRoom)
+			}
+
+			// the rest is read back once the decoder consumed everything
+			b.skip(0)
+			for {
+				seg, ok := b.unspill(true)
+				if !ok {
+					break
+				}
+				read = append(read, seg)
+			}
+
+			if len(read) != len(onDisk) {
+				t.Fatalf("%v segments read back, want %v", len(read), len(onDisk))
+			}
+			for i := range read {
+				if !read[i].seen.Equal(onDisk[i].seen) {
+					t.Errorf("segment %v seen %v, want %v", i, read[i].seen, onDisk[i].seen)
+				}
+				read[i].seen, onDisk[i].seen = time.Time{}, time.Time{}
+				if !reflect.DeepEqual(read[i], onDisk[i]) {
+					t.Errorf("segment %v: got %+v, want %+v", i, read[i], onDisk[i])
+				}
+			}
+			if b.spilled != 0 || b.spillRead != 0 || b.spillWrite != 0 {
+				t.Errorf("spill not reset: %v bytes, read at %v, write at %v", b.spilled, b.spillRead, b.spillWrite)
+			}
+		})
+	}
+}
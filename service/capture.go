@@ -2,19 +2,32 @@ package service
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
-	"github.com/google/gopacket/pcap"
 	"github.com/google/gopacket/reassembly"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"net/http"
 	"os"
 	"os/signal"
 	"runtime"
+	"sync"
 	"syscall"
+	"time"
 )
 
 type Context struct {
 	ci gopacket.CaptureInfo
+	// interface the packet was captured on
+	iface string
+	// agent that captured the packet, if it was streamed to a collector
+	agent string
+	// raw packet and its link type, so streams can write it to their flow file
+	data     []byte
+	linkType uint16
 }
 
 func (c Context) GetCaptureInfo() gopacket.CaptureInfo {
@@ -28,23 +41,24 @@ func Capture(cmd *cobra.Command, args []string) {
 	ctx := context.Background()
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
-	config()
+	config(ctx)
 
-	ocs = &opCodeStructs{
-		structs: make(map[uint16]string),
-	}
+	sa := newSharedAssembler(newAssembler(ctx))
+	go sa.sweepIdle(ctx)
 
-	sf := &shineStreamFactory{
-		shineContext: ctx,
-	}
-
-	sp := reassembly.NewStreamPool(sf)
-	a := reassembly.NewAssembler(sp)
+	http.HandleFunc("/capture/stats", captureStatsHandler)
+	http.HandleFunc("/capture/trigger", captureTriggerHandler)
+	http.HandleFunc("/capture/streams", captureStreamsHandler)
+	http.HandleFunc("/capture/buffers", captureBuffersHandler)
+	http.HandleFunc("/capture/pipeline", capturePipelineHandler)
 
 	go startUI(ctx)
-	go capturePackets(ctx, a)
 
-	em.Entities = make(map[uint16][]Movement)
+	if input := viper.GetString("capture.input"); input != "" {
+		go captureInput(ctx, sa, input)
+	} else {
+		go capturePackets(ctx, sa)
+	}
 
 	c := make(chan os.Signal, 2)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM) // subscribe to system signals
@@ -52,86 +66,287 @@ func Capture(cmd *cobra.Command, args []string) {
 		select {
 		case <-c:
 			cancel()
-			//generateOpCodeSwitch()
+			closeFlowFiles()
+			//generateStructDefinitions()
 			exportEntitiesMovements()
+			exportSessions()
 		}
 	}
 }
 
+// setup the reassembly pipeline shared by live captures and capture files
+func newAssembler(ctx context.Context) (*shineStreamFactory, *reassembly.Assembler) {
+	ocs = &opCodeStructs{
+		structs: make(map[uint16]string),
+	}
+
+	em.Entities = make(map[uint16][]Movement)
 
-func capturePackets(ctx context.Context, a *reassembly.Assembler) {
-	defer a.FlushAll()
+	sf := &shineStreamFactory{
+		shineContext: ctx,
+	}
 
-	handle, err := pcap.OpenLive(iface, int32(snaplen), true, pcap.BlockForever)
-	if err != nil {
-		log.Fatal("error opening pcap handle: ", err)
+	sp := reassembly.NewStreamPool(sf)
+	return sf, reassembly.NewAssembler(sp)
+}
+
+// feed the tcp layer of a packet to the assembler, using the timestamp of when the packet was captured
+func assemblePacket(a *reassembly.Assembler, packet gopacket.Packet, c Context) {
+	tcp, ok := packet.TransportLayer().(*layers.TCP)
+	if !ok || packet.NetworkLayer() == nil {
+		return
+	}
+	c.ci = packet.Metadata().CaptureInfo
+	c.data = packet.Data()
+	assembleTCP(a, packet.NetworkLayer().NetworkFlow(), tcp, c)
+}
+
+func assembleTCP(a *reassembly.Assembler, netFlow gopacket.Flow, tcp *layers.TCP, c Context) {
+	// same as the bpf filter, for sources that can't compile one
+	if !ports.has(int(tcp.SrcPort)) && !ports.has(int(tcp.DstPort)) {
+		return
+	}
+	c.restoreCaptured()
+	a.AssembleWithContext(netFlow, tcp, c)
+}
+
+// packetReader reads packets and knows which link type they were captured with
+type packetReader interface {
+	gopacket.PacketDataSource
+	LinkType() layers.LinkType
+}
+
+// captureHandle is a source of live packets, implemented by each capture backend
+type captureHandle interface {
+	packetReader
+	Stats() (captureStats, error)
+	Close()
+}
+
+// captureStats counters reported by the capture backend
+type captureStats struct {
+	Backend   string `json:"backend"`
+	Received  uint64 `json:"received"`
+	Dropped   uint64 `json:"dropped"`
+	IfDropped uint64 `json:"ifDropped,omitempty"`
+	// number of times the afpacket ring was full
+	QueueFreezes uint64 `json:"queueFreezes,omitempty"`
+}
+
+// captureHandles handles of the running live capture, by interface
+type captureHandles struct {
+	handles map[string]captureHandle
+	mu      sync.Mutex
+}
+
+var live = &captureHandles{
+	handles: make(map[string]captureHandle),
+}
+
+func (ch *captureHandles) add(iface string, handle captureHandle) {
+	ch.mu.Lock()
+	ch.handles[iface] = handle
+	ch.mu.Unlock()
+}
+
+// counters of every interface
+func (ch *captureHandles) stats() map[string]captureStats {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	stats := make(map[string]captureStats)
+	for iface, h := range ch.handles {
+		s, err := h.Stats()
+		if err != nil {
+			log.Errorf("[%v] %v", iface, err)
+			continue
+		}
+		stats[iface] = s
+	}
+	return stats
+}
+
+// sharedAssembler the assembler isn't safe for concurrent use, so capture goroutines of every interface go through the lock
+type sharedAssembler struct {
+	sf *shineStreamFactory
+	a  *reassembly.Assembler
+	// capture timestamp of the most recent packet, idle streams are measured against it so capture files age like live traffic
+	latest time.Time
+	mu     sync.Mutex
+}
+
+func newSharedAssembler(sf *shineStreamFactory, a *reassembly.Assembler) *sharedAssembler {
+	return &sharedAssembler{
+		sf: sf,
+		a:  a,
 	}
-	if err := handle.SetBPFFilter(filter); err != nil {
-		log.Fatal("error setting BPF filter: ", err)
+}
+
+func (sa *sharedAssembler) assemble(netFlow gopacket.Flow, tcp *layers.TCP, c Context) {
+	sa.mu.Lock()
+	assembleTCP(sa.a, netFlow, tcp, c)
+	sa.seen(c.ci.Timestamp)
+	sa.mu.Unlock()
+}
+
+func (sa *sharedAssembler) assemblePacket(packet gopacket.Packet, c Context) {
+	sa.mu.Lock()
+	assemblePacket(sa.a, packet, c)
+	sa.seen(packet.Metadata().Timestamp)
+	sa.mu.Unlock()
+}
+
+func (sa *sharedAssembler) seen(t time.Time) {
+	if t.After(sa.latest) {
+		sa.latest = t
 	}
+}
+
+func (sa *sharedAssembler) flushAll() {
+	sa.mu.Lock()
+	sa.sf.closeReason = "capture ended"
+	sa.a.FlushAll()
+	sa.sf.closeReason = ""
+	sa.mu.Unlock()
+}
 
-	packetSource := gopacket.NewPacketSource(handle, handle.LinkType())
+// sweepIdle periodically closes streams that saw no packets for stream.idleTimeout
+// half closed or abandoned connections would otherwise keep their decoders and buffers until the capture ends
+func (sa *sharedAssembler) sweepIdle(ctx context.Context) {
+	timeout := viper.GetDuration("stream.idleTimeout")
+	interval := viper.GetDuration("stream.sweepInterval")
+	if timeout <= 0 || interval <= 0 {
+		log.Warning("idle streams won't be closed until the capture ends")
+		return
+	}
 
+	t := time.NewTicker(interval)
+	defer t.Stop()
 	for {
 		select {
 		case <-ctx.Done():
-			log.Warningf("capture canceled")
 			return
-		case packet := <-packetSource.Packets():
-			if tcp, ok := packet.TransportLayer().(*layers.TCP); ok {
-				c := Context{
-					ci: packet.Metadata().CaptureInfo,
+		case <-t.C:
+			sa.sweep(timeout)
+		}
+	}
+}
+
+func (sa *sharedAssembler) sweep(timeout time.Duration) {
+	sa.mu.Lock()
+	defer sa.mu.Unlock()
+	if sa.latest.IsZero() {
+		return
+	}
+	sa.sf.closeReason = "timeout"
+	flushed, closed := sa.a.FlushCloseOlderThan(sa.latest.Add(-timeout))
+	sa.sf.closeReason = ""
+	if flushed > 0 || closed > 0 {
+		log.Infof("idle sweep: flushed %v streams, closed %v streams", flushed, closed)
+	}
+}
+
+// returned by compileBPFFilter when the binary is built without libpcap
+var errNoBPFCompiler = errors.New("bpf filters can't be compiled without libpcap")
+
+// open a live capture on an interface using the configured backend
+func openCaptureHandle(iface string) (captureHandle, error) {
+	var (
+		h   captureHandle
+		err error
+	)
+	switch backend := viper.GetString("network.backend"); backend {
+	case "pcap":
+		h, err = openPcap(iface, snaplen, filter)
+	case "afpacket":
+		h, err = openAFPacket(iface, snaplen, filter)
+	default:
+		return nil, fmt.Errorf("unknown capture backend %v", backend)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if h.LinkType() == truncatedLinuxSLL2 {
+		return sll2Handle{h}, nil
+	}
+	return h, nil
+}
+
+// serve the counters of the live capture as json
+func captureStatsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(live.stats()); err != nil {
+		log.Error(err)
+	}
+}
+
+// capture on every configured interface, all flows are reassembled by the same assembler
+func capturePackets(ctx context.Context, sa *sharedAssembler) {
+	defer sa.flushAll()
+
+	for _, name := range ifaces {
+		handle, err := openCaptureHandle(name)
+		if err != nil {
+			log.Fatalf("[%v] %v", name, err)
+		}
+		live.add(name, handle)
+		log.Infof("capturing on %v, link type %v", name, handle.LinkType())
+		go readPackets(ctx, name, handle, sa)
+	}
+
+	defer func() {
+		log.Infof("capture stats: %+v, dropped by backpressure: %+v", live.stats(), pipelineDrops.snapshot())
+	}()
+
+	<-ctx.Done()
+	log.Warningf("capture canceled")
+}
+
+// read packets from a capture file as they are written instead of capturing live, "-" reads from stdin
+// e.g: ssh server tcpdump -U -w - "tcp portrange 9000-9600" | sniffer capture --input -
+func captureInput(ctx context.Context, sa *sharedAssembler, input string) {
+	defer sa.flushAll()
+
+	pf := openCaptureFile(input)
+	defer pf.Close()
+
+	log.Infof("capturing from %v, link type %v", input, pf.LinkType())
+	readPackets(ctx, "", pf, sa)
+	log.Infof("no more packets can be read from %v", input)
+}
+
+// read packets from an interface using the configured decoder
+func readPackets(ctx context.Context, iface string, handle packetReader, sa *sharedAssembler) {
+	switch decoder := viper.GetString("network.decoder"); decoder {
+	case "parser":
+		err := parsePackets(ctx, handle, handle.LinkType(), func(netFlow gopacket.Flow, tcp *layers.TCP, ci gopacket.CaptureInfo, data []byte) {
+			sa.assemble(netFlow, tcp, Context{
+				ci:       ci,
+				iface:    iface,
+				data:     data,
+				linkType: uint16(handle.LinkType()),
+			})
+		})
+		if err != nil {
+			log.Errorf("[%v] %v", iface, err)
+		}
+	case "packet":
+		packetSource := gopacket.NewPacketSource(handle, handle.LinkType())
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case packet, ok := <-packetSource.Packets():
+				if !ok {
+					log.Warningf("[%v] no more packets can be read", iface)
+					return
 				}
-				a.AssembleWithContext(packet.NetworkLayer().NetworkFlow(), tcp, c)
+				sa.assemblePacket(packet, Context{
+					iface:    iface,
+					linkType: uint16(handle.LinkType()),
+				})
 			}
 		}
+	default:
+		log.Fatalf("unknown decoder %v", decoder)
 	}
-	//
-	//	var parser * gopacket.DecodingLayerParser
-	//	var lb layers.Loopback
-	//	var eth layers.Ethernet
-	//	var ip4 layers.IPv4
-	//	var tcp layers.TCP
-	//	var payload gopacket.Payload
-	//
-	//	if viper.GetBool("network.loopback") {
-	//		parser = gopacket.NewDecodingLayerParser(layers.LayerTypeLoopback, &lb, &ip4, &tcp, &payload)
-	//	} else {
-	//		parser = gopacket.NewDecodingLayerParser(layers.LayerTypeEthernet, &eth, &ip4, &tcp, &payload)
-	//	}
-	//
-	//	decoded := make([]gopacket.LayerType, 4096, 4096)
-	//
-	//loop:
-	//	for {
-	//		data, ci, err := handle.ZeroCopyReadPacketData()
-	//
-	//		if err != nil {
-	//			log.Errorf("error getting packet: %v	", err)
-	//			continue
-	//		}
-	//		err = parser.DecodeLayers(data, &decoded)
-	//		if err != nil {
-	//			continue
-	//		}
-	//		foundNetLayer := false
-	//		var netFlow gopacket.Flow
-	//		for _, typ := range decoded {
-	//			switch typ {
-	//			case layers.LayerTypeIPv4:
-	//				netFlow = ip4.NetworkFlow()
-	//				foundNetLayer = true
-	//			case layers.LayerTypeTCP:
-	//				if foundNetLayer {
-	//					c := Context{
-	//						ci: ci,
-	//					}
-	//					a.AssembleWithContext(netFlow, &tcp, c)
-	//				} else {
-	//					log.Error("could not find IPv4 or IPv6 layer, ignoring")
-	//				}
-	//				continue loop
-	//			}
-	//		}
-	//	}
 }
@@ -0,0 +1,93 @@
+//go:build linux && cgo
+// +build linux,cgo
+
+package service
+
+import (
+	"fmt"
+	"github.com/google/gopacket/afpacket"
+	"github.com/google/gopacket/layers"
+	"github.com/spf13/viper"
+	"os"
+)
+
+type afpacketHandle struct {
+	*afpacket.TPacket
+}
+
+// open a memory mapped TPACKET_V3 ring on the interface
+func openAFPacket(iface string, snaplen int, filter string) (captureHandle, error) {
+	frameSize, blockSize, numBlocks, err := afpacketComputeSize(viper.GetInt("network.afpacket.bufferSize"), snaplen, os.Getpagesize())
+	if err != nil {
+		return nil, err
+	}
+
+	tp, err := afpacket.NewTPacket(
+		afpacket.OptInterface(iface),
+		afpacket.OptFrameSize(frameSize),
+		afpacket.OptBlockSize(blockSize),
+		afpacket.OptNumBlocks(numBlocks),
+		afpacket.OptTPacketVersion(afpacket.TPacketVersion3),
+		afpacket.SocketRaw,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error opening afpacket handle: %v", err)
+	}
+
+	h := afpacketHandle{tp}
+	if err := h.setBPFFilter(filter, snaplen); err == errNoBPFCompiler {
+		log.Warningf("%v, packets will be filtered after capture", err)
+	} else if err != nil {
+		h.Close()
+		return nil, fmt.Errorf("error setting BPF filter: %v", err)
+	}
+	return h, nil
+}
+
+// compile the filter expression and attach it to the socket
+func (h afpacketHandle) setBPFFilter(filter string, snaplen int) error {
+	raw, err := compileBPFFilter(h.LinkType(), snaplen, filter)
+	if err != nil {
+		return err
+	}
+	return h.SetBPF(raw)
+}
+
+// raw sockets always deliver ethernet frames
+func (h afpacketHandle) LinkType() layers.LinkType {
+	return layers.LinkTypeEthernet
+}
+
+func (h afpacketHandle) Stats() (captureStats, error) {
+	_, s, err := h.SocketStats()
+	if err != nil {
+		return captureStats{}, err
+	}
+	return captureStats{
+		Backend:      "afpacket",
+		Received:     uint64(s.Packets()),
+		Dropped:      uint64(s.Drops()),
+		QueueFreezes: uint64(s.QueueFreezes()),
+	}, nil
+}
+
+// size the ring so that it takes roughly targetSizeMb megabytes
+func afpacketComputeSize(targetSizeMb int, snaplen int, pageSize int) (frameSize int, blockSize int, numBlocks int, err error) {
+	if snaplen <= 0 {
+		return 0, 0, 0, fmt.Errorf("network.snaplen must be positive, got %v", snaplen)
+	}
+	if snaplen < pageSize {
+		frameSize = pageSize / (pageSize / snaplen)
+	} else {
+		frameSize = (snaplen/pageSize + 1) * pageSize
+	}
+
+	// 128 is the default from the gopacket author
+	blockSize = frameSize * 128
+	numBlocks = (targetSizeMb * 1024 * 1024) / blockSize
+
+	if numBlocks == 0 {
+		return 0, 0, 0, fmt.Errorf("afpacket buffer size of %vMB is too small for snaplen %v", targetSizeMb, snaplen)
+	}
+	return frameSize, blockSize, numBlocks, nil
+}
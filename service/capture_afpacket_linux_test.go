@@ -0,0 +1,41 @@
+//go:build linux && cgo
+// +build linux,cgo
+
+package service
+
+import "testing"
+
+func TestAFPacketComputeSize(t *testing.T) {
+	tests := []struct {
+		name          string
+		targetSizeMb  int
+		snaplen       int
+		wantFrameSize int
+		wantBlockSize int
+		wantNumBlocks int
+		wantErr       bool
+	}{
+		{"defaults", 16, 65536, 69632, 69632 * 128, 1, false},
+		{"bigger buffer", 64, 65536, 69632, 69632 * 128, 7, false},
+		{"buffer smaller than a block", 8, 65536, 0, 0, 0, true},
+		{"snaplen under a page", 8, 1500, 2048, 2048 * 128, 32, false},
+		{"snaplen of a page", 8, 4096, 8192, 8192 * 128, 8, false},
+		{"snaplen not set", 8, 0, 0, 0, 0, true},
+		{"negative snaplen", 8, -1, 0, 0, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frameSize, blockSize, numBlocks, err := afpacketComputeSize(tt.targetSizeMb, tt.snaplen, 4096)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if frameSize != tt.wantFrameSize || blockSize != tt.wantBlockSize || numBlocks != tt.wantNumBlocks {
+				t.Errorf("got %v %v %v, want %v %v %v", frameSize, blockSize, numBlocks, tt.wantFrameSize, tt.wantBlockSize, tt.wantNumBlocks)
+			}
+		})
+	}
+}
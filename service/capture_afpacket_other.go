@@ -0,0 +1,10 @@
+//go:build !linux || !cgo
+// +build !linux !cgo
+
+package service
+
+import "fmt"
+
+func openAFPacket(iface string, snaplen int, filter string) (captureHandle, error) {
+	return nil, fmt.Errorf("the afpacket capture backend is only available on linux, built with cgo")
+}
@@ -0,0 +1,72 @@
+//go:build cgo
+// +build cgo
+
+package service
+
+import (
+	"fmt"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+	"golang.org/x/net/bpf"
+)
+
+type pcapHandle struct {
+	*pcap.Handle
+}
+
+func openPcap(iface string, snaplen int, filter string) (captureHandle, error) {
+	handle, err := pcap.OpenLive(iface, int32(snaplen), true, pcap.BlockForever)
+	if err != nil {
+		return nil, fmt.Errorf("error opening pcap handle: %v", err)
+	}
+	if err := handle.SetBPFFilter(filter); err != nil {
+		handle.Close()
+		return nil, fmt.Errorf("error setting BPF filter: %v", err)
+	}
+	return pcapHandle{handle}, nil
+}
+
+func (h pcapHandle) Stats() (captureStats, error) {
+	s, err := h.Handle.Stats()
+	if err != nil {
+		return captureStats{}, err
+	}
+	return captureStats{
+		Backend:   "pcap",
+		Received:  uint64(s.PacketsReceived),
+		Dropped:   uint64(s.PacketsDropped),
+		IfDropped: uint64(s.PacketsIfDropped),
+	}, nil
+}
+
+// open a pcap or pcapng file with libpcap, filtering it with the configured bpf filter
+// "-" reads from stdin
+func openPacketFile(path string) packetFile {
+	handle, err := pcap.OpenOffline(path)
+	if err != nil {
+		log.Fatalf("error opening capture file %v: %v", path, err)
+	}
+
+	if err := handle.SetBPFFilter(filter); err != nil {
+		log.Fatal("error setting BPF filter: ", err)
+	}
+	return handle
+}
+
+// compile a filter expression with libpcap, so it can be attached to sockets not opened by libpcap
+func compileBPFFilter(linkType layers.LinkType, snaplen int, filter string) ([]bpf.RawInstruction, error) {
+	pcapBPF, err := pcap.CompileBPFFilter(linkType, snaplen, filter)
+	if err != nil {
+		return nil, err
+	}
+	raw := make([]bpf.RawInstruction, 0, len(pcapBPF))
+	for _, i := range pcapBPF {
+		raw = append(raw, bpf.RawInstruction{
+			Op: i.Code,
+			Jt: i.Jt,
+			Jf: i.Jf,
+			K:  i.K,
+		})
+	}
+	return raw, nil
+}
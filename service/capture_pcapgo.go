@@ -0,0 +1,65 @@
+//go:build !cgo
+// +build !cgo
+
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"golang.org/x/net/bpf"
+	"os"
+)
+
+// pcapng files start with a section header block
+var pcapngMagic = []byte{0x0a, 0x0d, 0x0d, 0x0a}
+
+type pcapgoFile struct {
+	packetReader
+	f *os.File
+}
+
+func (pf pcapgoFile) Close() {
+	if err := pf.f.Close(); err != nil {
+		log.Error(err)
+	}
+}
+
+// open a pcap or pcapng file without libpcap, "-" reads from stdin
+// there is no bpf filter, packets are filtered by port when they are assembled
+func openPacketFile(path string) packetFile {
+	f := os.Stdin
+	if path != "-" {
+		var err error
+		f, err = os.Open(path)
+		if err != nil {
+			log.Fatalf("error opening capture file %v: %v", path, err)
+		}
+	}
+
+	r := bufio.NewReader(f)
+	magic, err := r.Peek(len(pcapngMagic))
+	if err != nil {
+		log.Fatalf("error reading capture file %v: %v", path, err)
+	}
+
+	var pr packetReader
+	if bytes.Equal(magic, pcapngMagic) {
+		pr, err = pcapgo.NewNgReader(r, pcapgo.DefaultNgReaderOptions)
+	} else {
+		pr, err = pcapgo.NewReader(r)
+	}
+	if err != nil {
+		log.Fatalf("error opening capture file %v: %v", path, err)
+	}
+
+	return pcapgoFile{
+		packetReader: pr,
+		f:            f,
+	}
+}
+
+func compileBPFFilter(linkType layers.LinkType, snaplen int, filter string) ([]bpf.RawInstruction, error) {
+	return nil, errNoBPFCompiler
+}
@@ -0,0 +1,60 @@
+//go:build !cgo
+// +build !cgo
+
+package service
+
+import (
+	"fmt"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"sync"
+)
+
+type ethernetHandle struct {
+	*pcapgo.EthernetHandle
+	// the kernel resets its counters every time they are read
+	received, dropped uint64
+	mu                sync.Mutex
+}
+
+// open a raw socket on the interface without libpcap
+// there is no bpf filter, packets are filtered by port when they are assembled
+func openPcap(iface string, snaplen int, filter string) (captureHandle, error) {
+	eh, err := pcapgo.NewEthernetHandle(iface)
+	if err != nil {
+		return nil, fmt.Errorf("error opening ethernet handle: %v", err)
+	}
+	if err := eh.SetCaptureLength(snaplen); err != nil {
+		eh.Close()
+		return nil, err
+	}
+	if err := eh.SetPromiscuous(true); err != nil {
+		eh.Close()
+		return nil, err
+	}
+	log.Warningf("%v, packets will be filtered after capture", errNoBPFCompiler)
+	return &ethernetHandle{
+		EthernetHandle: eh,
+	}, nil
+}
+
+// raw sockets always deliver ethernet frames
+func (h *ethernetHandle) LinkType() layers.LinkType {
+	return layers.LinkTypeEthernet
+}
+
+func (h *ethernetHandle) Stats() (captureStats, error) {
+	s, err := h.EthernetHandle.Stats()
+	if err != nil {
+		return captureStats{}, err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.received += uint64(s.Packets)
+	h.dropped += uint64(s.Drops)
+	return captureStats{
+		Backend:  "pcapgo",
+		Received: h.received,
+		Dropped:  h.dropped,
+	}, nil
+}
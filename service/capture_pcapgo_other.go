@@ -0,0 +1,10 @@
+//go:build !cgo && !linux
+// +build !cgo,!linux
+
+package service
+
+import "fmt"
+
+func openPcap(iface string, snaplen int, filter string) (captureHandle, error) {
+	return nil, fmt.Errorf("live capture without libpcap is only available on linux")
+}
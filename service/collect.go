@@ -0,0 +1,124 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"runtime"
+	"strings"
+	"syscall"
+)
+
+// Collect packets streamed by agents, and decode them
+func Collect(cmd *cobra.Command, args []string) {
+	runtime.GOMAXPROCS(runtime.NumCPU())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	config(ctx)
+
+	sa := newSharedAssembler(newAssembler(ctx))
+	go sa.sweepIdle(ctx)
+
+	addr := viper.GetString("collect.listen")
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Infof("waiting for agents on %v", addr)
+
+	http.HandleFunc("/capture/trigger", captureTriggerHandler)
+	http.HandleFunc("/capture/streams", captureStreamsHandler)
+	http.HandleFunc("/capture/buffers", captureBuffersHandler)
+	http.HandleFunc("/capture/pipeline", capturePipelineHandler)
+
+	go startUI(ctx)
+	go acceptAgents(ctx, l, sa)
+
+	c := make(chan os.Signal, 2)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM) // subscribe to system signals
+	<-c
+	cancel()
+
+	if err := l.Close(); err != nil {
+		log.Error(err)
+	}
+
+	sa.flushAll()
+	closeFlowFiles()
+	exportEntitiesMovements()
+	exportSessions()
+}
+
+func acceptAgents(ctx context.Context, l net.Listener, sa *sharedAssembler) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Error(err)
+			continue
+		}
+		go collectAgent(ctx, conn, sa)
+	}
+}
+
+// read the pcapng stream of an agent, and feed its packets to the assembler
+func collectAgent(ctx context.Context, conn net.Conn, sa *sharedAssembler) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	name, err := r.ReadString('\n')
+	if err != nil {
+		log.Errorf("could not read agent name from %v: %v", conn.RemoteAddr(), err)
+		return
+	}
+	name = strings.TrimSpace(name)
+
+	ng, err := pcapgo.NewNgReader(r, pcapgo.DefaultNgReaderOptions)
+	if err != nil {
+		log.Errorf("agent %v: %v", name, err)
+		return
+	}
+
+	log.Infof("agent %v connected from %v", name, conn.RemoteAddr())
+
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+
+		data, ci, err := ng.ReadPacketData()
+		if err != nil {
+			if err == io.EOF {
+				log.Warningf("agent %v disconnected", name)
+			} else {
+				log.Warningf("agent %v disconnected: %v", name, err)
+			}
+			return
+		}
+
+		intf, err := ng.Interface(ci.InterfaceIndex)
+		if err != nil {
+			log.Errorf("agent %v: %v", name, err)
+			continue
+		}
+
+		packet := gopacket.NewPacket(data, intf.LinkType, gopacket.Default)
+		packet.Metadata().CaptureInfo = ci
+
+		sa.assemblePacket(packet, Context{
+			iface:    intf.Name,
+			agent:    name,
+			linkType: uint16(intf.LinkType),
+		})
+	}
+}
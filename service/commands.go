@@ -0,0 +1,46 @@
+package service
+
+import (
+	"fmt"
+	"github.com/spf13/viper"
+	"regexp"
+	"strconv"
+)
+
+// commandDepartment a department of the commands file, its commands are listed as NAME = 0xID
+type commandDepartment struct {
+	HexID    int    `mapstructure:"hexId"`
+	Name     string `mapstructure:"name"`
+	Commands string `mapstructure:"commands"`
+}
+
+// operation codes listed in protocol.commands, and their names
+var commandNames map[uint16]string
+
+var commandLine = regexp.MustCompile(`(\w+)\s*=\s*0x([0-9A-Fa-f]+)`)
+
+// opcode = department << 10 | command
+func loadCommandNames(path string) (map[uint16]string, error) {
+	v := viper.New()
+	v.SetConfigFile(path)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, err
+	}
+
+	var departments []commandDepartment
+	if err := v.UnmarshalKey("departments", &departments); err != nil {
+		return nil, err
+	}
+
+	names := make(map[uint16]string)
+	for _, d := range departments {
+		for _, m := range commandLine.FindAllStringSubmatch(d.Commands, -1) {
+			cmd, err := strconv.ParseUint(m[2], 16, 16)
+			if err != nil {
+				return nil, fmt.Errorf("department %v: %v", d.Name, err)
+			}
+			names[uint16(d.HexID)<<10|uint16(cmd)] = m[1]
+		}
+	}
+	return names, nil
+}
@@ -0,0 +1,213 @@
+// Code generated by go run ./internal/corestructs; DO NOT EDIT.
+
+package service
+
+import (
+	"github.com/shine-o/shine.engine.core/structs"
+	"reflect"
+)
+
+// coreStructs struct types of shine.engine.core, definitions in protocol.structs and command names refer to them by name
+var coreStructs = map[string]reflect.Type{
+	"AbstateBit":                                reflect.TypeOf(structs.AbstateBit{}),
+	"AbstateInformation":                        reflect.TypeOf(structs.AbstateInformation{}),
+	"AvatarInformation":                         reflect.TypeOf(structs.AvatarInformation{}),
+	"BriefInfoRegenMobCmdFlag":                  reflect.TypeOf(structs.BriefInfoRegenMobCmdFlag{}),
+	"CharBriefInfoCamp":                         reflect.TypeOf(structs.CharBriefInfoCamp{}),
+	"CharBriefInfoNotCamped":                    reflect.TypeOf(structs.CharBriefInfoNotCamped{}),
+	"CharIdChangeData":                          reflect.TypeOf(structs.CharIdChangeData{}),
+	"CharMysteryVaultUiStateCmd":                reflect.TypeOf(structs.CharMysteryVaultUiStateCmd{}),
+	"CharTitleBriefInfo":                        reflect.TypeOf(structs.CharTitleBriefInfo{}),
+	"CharTitleInfo":                             reflect.TypeOf(structs.CharTitleInfo{}),
+	"CharUseItemMiniMonsterInfoClientCmd":       reflect.TypeOf(structs.CharUseItemMiniMonsterInfoClientCmd{}),
+	"ChargedItemInfo":                           reflect.TypeOf(structs.ChargedItemInfo{}),
+	"EquipmentUpgrade":                          reflect.TypeOf(structs.EquipmentUpgrade{}),
+	"GameOptionData":                            reflect.TypeOf(structs.GameOptionData{}),
+	"GuildAcademyClient":                        reflect.TypeOf(structs.GuildAcademyClient{}),
+	"GuildClient":                               reflect.TypeOf(structs.GuildClient{}),
+	"HolyPromiseDate":                           reflect.TypeOf(structs.HolyPromiseDate{}),
+	"HolyPromiseInfo":                           reflect.TypeOf(structs.HolyPromiseInfo{}),
+	"ItemInventory":                             reflect.TypeOf(structs.ItemInventory{}),
+	"ItemPacketInfo":                            reflect.TypeOf(structs.ItemPacketInfo{}),
+	"KeyMapData":                                reflect.TypeOf(structs.KeyMapData{}),
+	"MapLogoutCmd":                              reflect.TypeOf(structs.MapLogoutCmd{}),
+	"Name256Byte":                               reflect.TypeOf(structs.Name256Byte{}),
+	"Name3":                                     reflect.TypeOf(structs.Name3{}),
+	"Name4":                                     reflect.TypeOf(structs.Name4{}),
+	"Name5":                                     reflect.TypeOf(structs.Name5{}),
+	"Name8":                                     reflect.TypeOf(structs.Name8{}),
+	"NcActChangeModeReq":                        reflect.TypeOf(structs.NcActChangeModeReq{}),
+	"NcActChatReq":                              reflect.TypeOf(structs.NcActChatReq{}),
+	"NcActGatherStartReq":                       reflect.TypeOf(structs.NcActGatherStartReq{}),
+	"NcActMoveRunCmd":                           reflect.TypeOf(structs.NcActMoveRunCmd{}),
+	"NcActMoveSpeedCmd":                         reflect.TypeOf(structs.NcActMoveSpeedCmd{}),
+	"NcActMoveWalkCmd":                          reflect.TypeOf(structs.NcActMoveWalkCmd{}),
+	"NcActNpcClickCmd":                          reflect.TypeOf(structs.NcActNpcClickCmd{}),
+	"NcActSomeoneChangeModeCmd":                 reflect.TypeOf(structs.NcActSomeoneChangeModeCmd{}),
+	"NcActSomeoneFoldTentCmd":                   reflect.TypeOf(structs.NcActSomeoneFoldTentCmd{}),
+	"NcActSomeoneJumpCmd":                       reflect.TypeOf(structs.NcActSomeoneJumpCmd{}),
+	"NcActSomeoneMoveRunCmd":                    reflect.TypeOf(structs.NcActSomeoneMoveRunCmd{}),
+	"NcActSomeoneMoveWalkCmd":                   reflect.TypeOf(structs.NcActSomeoneMoveWalkCmd{}),
+	"NcActSomeoneMoveWalkCmdAttr":               reflect.TypeOf(structs.NcActSomeoneMoveWalkCmdAttr{}),
+	"NcActSomeoneProduceCastCmd":                reflect.TypeOf(structs.NcActSomeoneProduceCastCmd{}),
+	"NcActSomeoneProduceMakeCmd":                reflect.TypeOf(structs.NcActSomeoneProduceMakeCmd{}),
+	"NcActSomeoneShoutCmd":                      reflect.TypeOf(structs.NcActSomeoneShoutCmd{}),
+	"NcActSomeoneShoutCmdFlag":                  reflect.TypeOf(structs.NcActSomeoneShoutCmdFlag{}),
+	"NcActSomeoneShoutCmdSpeaker":               reflect.TypeOf(structs.NcActSomeoneShoutCmdSpeaker{}),
+	"NcActSomeoneStopCmd":                       reflect.TypeOf(structs.NcActSomeoneStopCmd{}),
+	"NcActStopReq":                              reflect.TypeOf(structs.NcActStopReq{}),
+	"NcAvatarCreateFailAck":                     reflect.TypeOf(structs.NcAvatarCreateFailAck{}),
+	"NcAvatarCreateReq":                         reflect.TypeOf(structs.NcAvatarCreateReq{}),
+	"NcAvatarCreateSuccAck":                     reflect.TypeOf(structs.NcAvatarCreateSuccAck{}),
+	"NcAvatarEraseReq":                          reflect.TypeOf(structs.NcAvatarEraseReq{}),
+	"NcAvatarEraseSuccAck":                      reflect.TypeOf(structs.NcAvatarEraseSuccAck{}),
+	"NcBatAbstateInformCmd":                     reflect.TypeOf(structs.NcBatAbstateInformCmd{}),
+	"NcBatAbstateInformNoEffectCmd":             reflect.TypeOf(structs.NcBatAbstateInformNoEffectCmd{}),
+	"NcBatAbstateResetCmd":                      reflect.TypeOf(structs.NcBatAbstateResetCmd{}),
+	"NcBatAbstateSetCmd":                        reflect.TypeOf(structs.NcBatAbstateSetCmd{}),
+	"NcBatCeaseFireCmd":                         reflect.TypeOf(structs.NcBatCeaseFireCmd{}),
+	"NcBatDotDamageCmd":                         reflect.TypeOf(structs.NcBatDotDamageCmd{}),
+	"NcBatHpChangeCmd":                          reflect.TypeOf(structs.NcBatHpChangeCmd{}),
+	"NcBatLpChangeCmd":                          reflect.TypeOf(structs.NcBatLpChangeCmd{}),
+	"NcBatSkillBashHitBlastCmd":                 reflect.TypeOf(structs.NcBatSkillBashHitBlastCmd{}),
+	"NcBatSkillBashHitDamageCmd":                reflect.TypeOf(structs.NcBatSkillBashHitDamageCmd{}),
+	"NcBatSkillBashHitDamageCmdSkillDamage":     reflect.TypeOf(structs.NcBatSkillBashHitDamageCmdSkillDamage{}),
+	"NcBatSkillBashHitDamageCmdSkillDamageFlag": reflect.TypeOf(structs.NcBatSkillBashHitDamageCmdSkillDamageFlag{}),
+	"NcBatSkillBashHitObjStartCmd":              reflect.TypeOf(structs.NcBatSkillBashHitObjStartCmd{}),
+	"NcBatSkillBashObjCastReq":                  reflect.TypeOf(structs.NcBatSkillBashObjCastReq{}),
+	"NcBatSomeoneSkillBashHitObjStartCmd":       reflect.TypeOf(structs.NcBatSomeoneSkillBashHitObjStartCmd{}),
+	"NcBatSpChangeCmd":                          reflect.TypeOf(structs.NcBatSpChangeCmd{}),
+	"NcBatTargetInfoCmd":                        reflect.TypeOf(structs.NcBatTargetInfoCmd{}),
+	"NcBoothEntryReq":                           reflect.TypeOf(structs.NcBoothEntryReq{}),
+	"NcBoothEntrySellAck":                       reflect.TypeOf(structs.NcBoothEntrySellAck{}),
+	"NcBoothEntrySellAckItemList":               reflect.TypeOf(structs.NcBoothEntrySellAckItemList{}),
+	"NcBoothRefreshReq":                         reflect.TypeOf(structs.NcBoothRefreshReq{}),
+	"NcBoothSearchBoothClosedCmd":               reflect.TypeOf(structs.NcBoothSearchBoothClosedCmd{}),
+	"NcBoothSomeoneOpenCmd":                     reflect.TypeOf(structs.NcBoothSomeoneOpenCmd{}),
+	"NcBriefInfoAbstateChangeCmd":               reflect.TypeOf(structs.NcBriefInfoAbstateChangeCmd{}),
+	"NcBriefInfoAbstateChangeListCmd":           reflect.TypeOf(structs.NcBriefInfoAbstateChangeListCmd{}),
+	"NcBriefInfoChangeDecorateCmd":              reflect.TypeOf(structs.NcBriefInfoChangeDecorateCmd{}),
+	"NcBriefInfoChangeUpgradeCmd":               reflect.TypeOf(structs.NcBriefInfoChangeUpgradeCmd{}),
+	"NcBriefInfoChangeWeaponCmd":                reflect.TypeOf(structs.NcBriefInfoChangeWeaponCmd{}),
+	"NcBriefInfoCharacterCmd":                   reflect.TypeOf(structs.NcBriefInfoCharacterCmd{}),
+	"NcBriefInfoDeleteCmd":                      reflect.TypeOf(structs.NcBriefInfoDeleteCmd{}),
+	"NcBriefInfoDroppedItemCmd":                 reflect.TypeOf(structs.NcBriefInfoDroppedItemCmd{}),
+	"NcBriefInfoDroppedItemCmdAttr":             reflect.TypeOf(structs.NcBriefInfoDroppedItemCmdAttr{}),
+	"NcBriefInfoInformCmd":                      reflect.TypeOf(structs.NcBriefInfoInformCmd{}),
+	"NcBriefInfoLoginCharacterCmd":              reflect.TypeOf(structs.NcBriefInfoLoginCharacterCmd{}),
+	"NcBriefInfoLoginCharacterCmdShapeData":     reflect.TypeOf(structs.NcBriefInfoLoginCharacterCmdShapeData{}),
+	"NcBriefInfoMobCmd":                         reflect.TypeOf(structs.NcBriefInfoMobCmd{}),
+	"NcBriefInfoMoverCmd":                       reflect.TypeOf(structs.NcBriefInfoMoverCmd{}),
+	"NcBriefInfoRegenMobCmd":                    reflect.TypeOf(structs.NcBriefInfoRegenMobCmd{}),
+	"NcBriefInfoRegenMoverCmd":                  reflect.TypeOf(structs.NcBriefInfoRegenMoverCmd{}),
+	"NcBriefInfoUnequipCmd":                     reflect.TypeOf(structs.NcBriefInfoUnequipCmd{}),
+	"NcCharAdminLevelInformCmd":                 reflect.TypeOf(structs.NcCharAdminLevelInformCmd{}),
+	"NcCharClientAutoPickCmd":                   reflect.TypeOf(structs.NcCharClientAutoPickCmd{}),
+	"NcCharClientBaseCmd":                       reflect.TypeOf(structs.NcCharClientBaseCmd{}),
+	"NcCharClientChargedBuffCmd":                reflect.TypeOf(structs.NcCharClientChargedBuffCmd{}),
+	"NcCharClientCoinInfoCmd":                   reflect.TypeOf(structs.NcCharClientCoinInfoCmd{}),
+	"NcCharClientItemCmd":                       reflect.TypeOf(structs.NcCharClientItemCmd{}),
+	"NcCharClientPassiveCmd":                    reflect.TypeOf(structs.NcCharClientPassiveCmd{}),
+	"NcCharClientQuestDoingCmd":                 reflect.TypeOf(structs.NcCharClientQuestDoingCmd{}),
+	"NcCharClientQuestDoneCmd":                  reflect.TypeOf(structs.NcCharClientQuestDoneCmd{}),
+	"NcCharClientQuestReadCmd":                  reflect.TypeOf(structs.NcCharClientQuestReadCmd{}),
+	"NcCharClientQuestRepeatCmd":                reflect.TypeOf(structs.NcCharClientQuestRepeatCmd{}),
+	"NcCharClientShapeCmd":                      reflect.TypeOf(structs.NcCharClientShapeCmd{}),
+	"NcCharClientSkillCmd":                      reflect.TypeOf(structs.NcCharClientSkillCmd{}),
+	"NcCharGetKeyMapCmd":                        reflect.TypeOf(structs.NcCharGetKeyMapCmd{}),
+	"NcCharGetShortcutDataCmd":                  reflect.TypeOf(structs.NcCharGetShortcutDataCmd{}),
+	"NcCharGuildAcademyCmd":                     reflect.TypeOf(structs.NcCharGuildAcademyCmd{}),
+	"NcCharGuildCmd":                            reflect.TypeOf(structs.NcCharGuildCmd{}),
+	"NcCharLoginAck":                            reflect.TypeOf(structs.NcCharLoginAck{}),
+	"NcCharLoginReq":                            reflect.TypeOf(structs.NcCharLoginReq{}),
+	"NcCharNewbieGuideViewSetCmd":               reflect.TypeOf(structs.NcCharNewbieGuideViewSetCmd{}),
+	"NcCharOptionGetShortcutSizeAck":            reflect.TypeOf(structs.NcCharOptionGetShortcutSizeAck{}),
+	"NcCharOptionGetShortcutSizeReq":            reflect.TypeOf(structs.NcCharOptionGetShortcutSizeReq{}),
+	"NcCharOptionGetWindowPosAck":               reflect.TypeOf(structs.NcCharOptionGetWindowPosAck{}),
+	"NcCharOptionImproveGetGameOptionCmd":       reflect.TypeOf(structs.NcCharOptionImproveGetGameOptionCmd{}),
+	"NcCharOptionShortcutSize":                  reflect.TypeOf(structs.NcCharOptionShortcutSize{}),
+	"NcCharOptionWindowPos":                     reflect.TypeOf(structs.NcCharOptionWindowPos{}),
+	"NcCharSkillClientCmd":                      reflect.TypeOf(structs.NcCharSkillClientCmd{}),
+	"NcCharStatRemainPointCmd":                  reflect.TypeOf(structs.NcCharStatRemainPointCmd{}),
+	"NcCharUiStateSaveReq":                      reflect.TypeOf(structs.NcCharUiStateSaveReq{}),
+	"NcCharUseItemMinimonUseBroadCmd":           reflect.TypeOf(structs.NcCharUseItemMinimonUseBroadCmd{}),
+	"NcChargedBoothSlotSizeCmd":                 reflect.TypeOf(structs.NcChargedBoothSlotSizeCmd{}),
+	"NcClientCharTitleCmd":                      reflect.TypeOf(structs.NcClientCharTitleCmd{}),
+	"NcCollectCardRegisterReq":                  reflect.TypeOf(structs.NcCollectCardRegisterReq{}),
+	"NcHolyPromiseListCmd":                      reflect.TypeOf(structs.NcHolyPromiseListCmd{}),
+	"NcITemChargedInventoryOpenReq":             reflect.TypeOf(structs.NcITemChargedInventoryOpenReq{}),
+	"NcItemCellChangeCmd":                       reflect.TypeOf(structs.NcItemCellChangeCmd{}),
+	"NcItemChangedInventoryOpenAck":             reflect.TypeOf(structs.NcItemChangedInventoryOpenAck{}),
+	"NcItemDropAck":                             reflect.TypeOf(structs.NcItemDropAck(0)),
+	"NcItemDropReq":                             reflect.TypeOf(structs.NcItemDropReq{}),
+	"NcItemEquipReq":                            reflect.TypeOf(structs.NcItemEquipReq{}),
+	"NcItemPickAck":                             reflect.TypeOf(structs.NcItemPickAck{}),
+	"NcItemPickReq":                             reflect.TypeOf(structs.NcItemPickReq{}),
+	"NcItemRewardInventoryOpenAck":              reflect.TypeOf(structs.NcItemRewardInventoryOpenAck{}),
+	"NcItemRewardInventoryOpenReq":              reflect.TypeOf(structs.NcItemRewardInventoryOpenReq{}),
+	"NcItemUnequipReq":                          reflect.TypeOf(structs.NcItemUnequipReq{}),
+	"NcItemUseReq":                              reflect.TypeOf(structs.NcItemUseReq{}),
+	"NcKqListTimeAck":                           reflect.TypeOf(structs.NcKqListTimeAck{}),
+	"NcKqTeamTypeCmd":                           reflect.TypeOf(structs.NcKqTeamTypeCmd{}),
+	"NcMapCanUseReviveItemCmd":                  reflect.TypeOf(structs.NcMapCanUseReviveItemCmd{}),
+	"NcMapFieldAttributeCmd":                    reflect.TypeOf(structs.NcMapFieldAttributeCmd{}),
+	"NcMapLinkOtherCmd":                         reflect.TypeOf(structs.NcMapLinkOtherCmd{}),
+	"NcMapLoginAck":                             reflect.TypeOf(structs.NcMapLoginAck{}),
+	"NcMapLoginCompleteCmd":                     reflect.TypeOf(structs.NcMapLoginCompleteCmd{}),
+	"NcMapLoginReq":                             reflect.TypeOf(structs.NcMapLoginReq{}),
+	"NcMapTownPortalAck":                        reflect.TypeOf(structs.NcMapTownPortalAck{}),
+	"NcMapTownPortalReq":                        reflect.TypeOf(structs.NcMapTownPortalReq{}),
+	"NcMiscGameTimeAck":                         reflect.TypeOf(structs.NcMiscGameTimeAck{}),
+	"NcMiscHeartBeatAck":                        reflect.TypeOf(structs.NcMiscHeartBeatAck{}),
+	"NcMiscSeedAck":                             reflect.TypeOf(structs.NcMiscSeedAck{}),
+	"NcMoverHungryCmd":                          reflect.TypeOf(structs.NcMoverHungryCmd{}),
+	"NcMoverMoveSpeedCmd":                       reflect.TypeOf(structs.NcMoverMoveSpeedCmd{}),
+	"NcMoverRideOnCmd":                          reflect.TypeOf(structs.NcMoverRideOnCmd{}),
+	"NcMoverSomeoneRideOffCmd":                  reflect.TypeOf(structs.NcMoverSomeoneRideOffCmd{}),
+	"NcMoverSomeoneRideOnCmd":                   reflect.TypeOf(structs.NcMoverSomeoneRideOnCmd{}),
+	"NcPrisonGetAck":                            reflect.TypeOf(structs.NcPrisonGetAck{}),
+	"NcQuestResetTimeClientCmd":                 reflect.TypeOf(structs.NcQuestResetTimeClientCmd{}),
+	"NcQuestScriptCmdAck":                       reflect.TypeOf(structs.NcQuestScriptCmdAck{}),
+	"NcQuestStartReq":                           reflect.TypeOf(structs.NcQuestStartReq{}),
+	"NcServerMenuAck":                           reflect.TypeOf(structs.NcServerMenuAck{}),
+	"NcServerMenuReq":                           reflect.TypeOf(structs.NcServerMenuReq{}),
+	"NcSoulStoneHpSomeoneUseCmd":                reflect.TypeOf(structs.NcSoulStoneHpSomeoneUseCmd{}),
+	"NcSoulStoneSpSomeoneUseCmd":                reflect.TypeOf(structs.NcSoulStoneSpSomeoneUseCmd{}),
+	"NcUserClientVersionCheckReq":               reflect.TypeOf(structs.NcUserClientVersionCheckReq{}),
+	"NcUserClientWrongVersionCheckAck":          reflect.TypeOf(structs.NcUserClientWrongVersionCheckAck{}),
+	"NcUserLoginAck":                            reflect.TypeOf(structs.NcUserLoginAck{}),
+	"NcUserLoginFailAck":                        reflect.TypeOf(structs.NcUserLoginFailAck{}),
+	"NcUserLoginWithOtpReq":                     reflect.TypeOf(structs.NcUserLoginWithOtpReq{}),
+	"NcUserLoginWorldAck":                       reflect.TypeOf(structs.NcUserLoginWorldAck{}),
+	"NcUserLoginWorldReq":                       reflect.TypeOf(structs.NcUserLoginWorldReq{}),
+	"NcUserUsLoginReq":                          reflect.TypeOf(structs.NcUserUsLoginReq{}),
+	"NcUserWillWorldSelectAck":                  reflect.TypeOf(structs.NcUserWillWorldSelectAck{}),
+	"NcUserWorldSelectAck":                      reflect.TypeOf(structs.NcUserWorldSelectAck{}),
+	"NcUserWorldSelectReq":                      reflect.TypeOf(structs.NcUserWorldSelectReq{}),
+	"NcZoneCharDataReq":                         reflect.TypeOf(structs.NcZoneCharDataReq{}),
+	"NcitemRelocateReq":                         reflect.TypeOf(structs.NcitemRelocateReq{}),
+	"NetCommand":                                reflect.TypeOf(structs.NetCommand{}),
+	"PartMark":                                  reflect.TypeOf(structs.PartMark{}),
+	"ProtoAvatarDeleteInfo":                     reflect.TypeOf(structs.ProtoAvatarDeleteInfo{}),
+	"ProtoAvatarShapeInfo":                      reflect.TypeOf(structs.ProtoAvatarShapeInfo{}),
+	"ProtoEquipment":                            reflect.TypeOf(structs.ProtoEquipment{}),
+	"ProtoItemPacketInformation":                reflect.TypeOf(structs.ProtoItemPacketInformation{}),
+	"ProtoNcCharClientItemCmdFlag":              reflect.TypeOf(structs.ProtoNcCharClientItemCmdFlag{}),
+	"ProtoTutorialInfo":                         reflect.TypeOf(structs.ProtoTutorialInfo{}),
+	"ServerMenu":                                reflect.TypeOf(structs.ServerMenu{}),
+	"ShineCoordType":                            reflect.TypeOf(structs.ShineCoordType{}),
+	"ShineDateTime":                             reflect.TypeOf(structs.ShineDateTime{}),
+	"ShineGuildScore":                           reflect.TypeOf(structs.ShineGuildScore{}),
+	"ShineItemVar":                              reflect.TypeOf(structs.ShineItemVar{}),
+	"ShineXYType":                               reflect.TypeOf(structs.ShineXYType{}),
+	"ShortCutData":                              reflect.TypeOf(structs.ShortCutData{}),
+	"SkillItemActionCoolTimeCmd":                reflect.TypeOf(structs.SkillItemActionCoolTimeCmd{}),
+	"SkillItemActionCoolTimeCmdGroup":           reflect.TypeOf(structs.SkillItemActionCoolTimeCmdGroup{}),
+	"SkillReadBlockClient":                      reflect.TypeOf(structs.SkillReadBlockClient{}),
+	"SkillReadBlockClientEmpower":               reflect.TypeOf(structs.SkillReadBlockClientEmpower{}),
+	"StopEmoticonDescript":                      reflect.TypeOf(structs.StopEmoticonDescript{}),
+	"StreetBoothSignBoard":                      reflect.TypeOf(structs.StreetBoothSignBoard{}),
+	"TM":                                        reflect.TypeOf(structs.TM{}),
+	"UseItemMiniMonsterInfo":                    reflect.TypeOf(structs.UseItemMiniMonsterInfo{}),
+	"WorldInfo":                                 reflect.TypeOf(structs.WorldInfo{}),
+}
@@ -1,44 +1,61 @@
 package service
 
 import (
-	"fmt"
+	"context"
 	"github.com/google/gopacket"
-	"github.com/google/gopacket/layers"
-	"github.com/google/gopacket/pcapgo"
+	"github.com/google/gopacket/reassembly"
 	"github.com/spf13/cobra"
-	"os"
-	"path/filepath"
-	"sync"
+	"runtime"
 )
 
-// Flows utility struct for storing raw packets
-type Flows struct {
-	pfm map[string][]gopacket.Packet
-	m   sync.Mutex
+// packetFile is a capture file opened for reading
+type packetFile interface {
+	packetReader
+	Close()
 }
 
-// write to disk pcapng files for each flow in the map
-func (pf *Flows) persist() {
-	pf.m.Lock()
-	for k, v := range pf.pfm {
-		pathName, err := filepath.Abs(fmt.Sprintf("%v%v", "output/", k))
-		if err != nil {
-			log.Fatal(err)
-		}
-		f, err := os.OpenFile(pathName, os.O_WRONLY|os.O_CREATE, 0666)
-
-		r, err := pcapgo.NewNgWriter(f, layers.LinkTypeEthernet)
-
-		for _, p := range v {
-			err = r.WritePacket(p.Metadata().CaptureInfo, p.Data())
-		}
-
-		r.Flush()
-		f.Close()
+// open a pcap or pcapng file, using the build's capture library
+func openCaptureFile(path string) packetFile {
+	pf := openPacketFile(path)
+	if pf.LinkType() == truncatedLinuxSLL2 {
+		return sll2File{pf}
 	}
-	pf.m.Unlock()
+	return pf
 }
 
+// Decode packets stored in a pcap or pcapng file, "-" reads from stdin
 func Decode(cmd *cobra.Command, args []string) {
+	runtime.GOMAXPROCS(runtime.NumCPU())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	config(ctx)
+
+	sf, a := newAssembler(ctx)
+
+	handle := openCaptureFile(args[0])
+	defer handle.Close()
+
+	n := decodePackets(a, handle)
+	log.Infof("read %v packets from %v", n, args[0])
 
-}
\ No newline at end of file
+	// the file is exhausted, so every stream is complete
+	sf.closeReason = "capture ended"
+	a.FlushAll()
+	sf.wg.Wait()
+
+	exportEntitiesMovements()
+	exportSessions()
+}
+
+// assemble every packet in the source until it runs out of packets
+func decodePackets(a *reassembly.Assembler, src packetReader) int {
+	var n int
+	ps := gopacket.NewPacketSource(src, src.LinkType())
+	for packet := range ps.Packets() {
+		assemblePacket(a, packet, Context{
+			linkType: uint16(src.LinkType()),
+		})
+		n++
+	}
+	return n
+}
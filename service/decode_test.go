@@ -0,0 +1,101 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/pcapgo"
+)
+
+// flow files are complete once the assembler's streams are done, as Decode and Replay export right after
+func TestFlowFilesWrittenOnWait(t *testing.T) {
+	capture, err := syntheticCapture(30, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(p serverPorts, persist bool, limits bufferSettings) {
+		ports = p
+		persistFlows = persist
+		bufferLimits = limits
+	}(ports, persistFlows, bufferLimits)
+	ports = serverPorts{
+		start: benchServerPort,
+		end:   benchServerPort,
+	}
+	persistFlows = true
+	bufferLimits = bufferSettings{
+		streamMax: minStreamBuffer,
+		globalMax: 1 << 30,
+		policy:    "drop",
+	}
+
+	before, _ := filepath.Glob("output/flows/*.pcapng")
+	existing := make(map[string]bool)
+	for _, path := range before {
+		existing[path] = true
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sf, a := newAssembler(ctx)
+
+	r, err := pcapgo.NewReader(bytes.NewReader(capture))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ps := gopacket.NewPacketSource(r, r.LinkType())
+	for packet := range ps.Packets() {
+		assemblePacket(a, packet, Context{
+			linkType: uint16(r.LinkType()),
+		})
+	}
+	sf.closeReason = "capture ended"
+	a.FlushAll()
+	sf.wg.Wait()
+
+	openFlows.mu.Lock()
+	open := len(openFlows.files)
+	openFlows.mu.Unlock()
+	if open != 0 {
+		t.Errorf("%v flow files still open", open)
+	}
+
+	after, _ := filepath.Glob("output/flows/*.pcapng")
+	frames := 0
+	for _, path := range after {
+		if existing[path] {
+			continue
+		}
+		defer os.Remove(path)
+
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		ng, err := pcapgo.NewNgReader(f, pcapgo.DefaultNgReaderOptions)
+		if err != nil {
+			t.Fatalf("%v: %v", path, err)
+		}
+		for {
+			_, _, err := ng.ReadPacketData()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("%v: %v", path, err)
+			}
+			frames++
+		}
+	}
+	// the handshake isn't written, it has no data
+	if frames == 0 {
+		t.Errorf("no frames written to %v flow files", len(after)-len(before))
+	}
+}
@@ -12,16 +12,19 @@ import (
 var em EntitiesMovements
 
 type EntitiesMovements struct {
-	Entities  map[uint16][]Movement
+	Entities map[uint16][]Movement
 	sync.Mutex
 }
 
 type Movement struct {
 	Timestamp time.Time
-	X, Y uint32
+	X, Y      uint32
+	// player session the movement was seen in
+	SessionID string `json:",omitempty"`
 }
+
 // store info of packets that contain coordinates
-func persistMovement(dp decodedPacket) {
+func persistMovement(dp decodedPacket, sessionID string) {
 	switch dp.packet.Base.OperationCode {
 	// server
 	// has handle identifier.
@@ -35,7 +38,8 @@ func persistMovement(dp decodedPacket) {
 		}
 		em.Lock()
 		em.Entities[nc.Handle] = append(em.Entities[nc.Handle], Movement{
-			Timestamp: time.Now(),
+			Timestamp: dp.seen,
+			SessionID: sessionID,
 			X:         nc.Location.X,
 			Y:         nc.Location.Y,
 		})
@@ -51,7 +55,8 @@ func persistMovement(dp decodedPacket) {
 		}
 		em.Lock()
 		em.Entities[nc.Handle] = append(em.Entities[nc.Handle], Movement{
-			Timestamp: time.Now(),
+			Timestamp: dp.seen,
+			SessionID: sessionID,
 			X:         nc.To.X,
 			Y:         nc.To.Y,
 		})
@@ -68,7 +73,8 @@ func persistMovement(dp decodedPacket) {
 		}
 		em.Lock()
 		em.Entities[1] = append(em.Entities[1], Movement{
-			Timestamp: time.Now(),
+			Timestamp: dp.seen,
+			SessionID: sessionID,
 			X:         nc.To.X,
 			Y:         nc.To.Y,
 		})
@@ -82,7 +88,8 @@ func persistMovement(dp decodedPacket) {
 		}
 		em.Lock()
 		em.Entities[1] = append(em.Entities[1], Movement{
-			Timestamp: time.Now(),
+			Timestamp: dp.seen,
+			SessionID: sessionID,
 			X:         nc.To.X,
 			Y:         nc.To.Y,
 		})
@@ -99,7 +106,8 @@ func persistMovement(dp decodedPacket) {
 		for _, c := range nc.Characters {
 			em.Lock()
 			em.Entities[c.Handle] = append(em.Entities[c.Handle], Movement{
-				Timestamp: time.Now(),
+				Timestamp: dp.seen,
+				SessionID: sessionID,
 				X:         c.Coordinates.XY.X,
 				Y:         c.Coordinates.XY.Y,
 			})
@@ -115,7 +123,8 @@ func persistMovement(dp decodedPacket) {
 		for _, m := range nc.Mobs {
 			em.Lock()
 			em.Entities[m.Handle] = append(em.Entities[m.Handle], Movement{
-				Timestamp: time.Now(),
+				Timestamp: dp.seen,
+				SessionID: sessionID,
 				X:         m.Coord.XY.X,
 				Y:         m.Coord.XY.Y,
 			})
@@ -130,7 +139,8 @@ func persistMovement(dp decodedPacket) {
 		}
 		em.Lock()
 		em.Entities[nc.Handle] = append(em.Entities[nc.Handle], Movement{
-			Timestamp: time.Now(),
+			Timestamp: dp.seen,
+			SessionID: sessionID,
 			X:         nc.Coordinates.XY.X,
 			Y:         nc.Coordinates.XY.Y,
 		})
@@ -156,9 +166,8 @@ func exportEntitiesMovements() {
 	if err != nil {
 		log.Error(err)
 	}
-	_,  _ = f.Write(b)
+	_, _ = f.Write(b)
 	em.Unlock()
 
 	f.Close()
 }
-
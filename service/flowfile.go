@@ -0,0 +1,260 @@
+package service
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/spf13/viper"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// flowFile pcapng file the packets of a connection are written to as they arrive
+// frames are held for a short while before they are written, so the shine packets they complete can be added as comments
+type flowFile struct {
+	path   string
+	f      *os.File
+	w      *bufio.Writer
+	closed bool
+	// frames not written yet, the first one has sequence number first
+	pending []*flowFrame
+	first   uint64
+	// comments of frames that were already written, logged once when the file is closed
+	late int
+	mu   sync.Mutex
+}
+
+// flowFrame a captured packet and the comments of the enhanced packet block it's written as
+type flowFrame struct {
+	ci       gopacket.CaptureInfo
+	data     []byte
+	comments []string
+	queued   time.Time
+}
+
+// flowFiles files that are still open, so they can be closed if the sniffer stops before their streams are complete
+type flowFiles struct {
+	files map[*flowFile]bool
+	mu    sync.Mutex
+}
+
+const (
+	// how long a frame waits for the decoders before it's written without comments
+	flowFrameHold = 2 * time.Second
+	// frames held at most, older frames are written when there are more
+	flowFrameMaxPending = 4096
+)
+
+var (
+	persistFlows bool
+	openFlows    = &flowFiles{
+		files: make(map[*flowFile]bool),
+	}
+)
+
+// characters that can't be used in file names on every platform
+var flowFileNames = strings.NewReplacer(":", "_", "->", "_", "[", "", "]", "")
+
+// create output/flows/<connection key>_<flow id>.pcapng
+func newFlowFile(ss *shineStream, linkType uint16) (*flowFile, error) {
+	dir, err := filepath.Abs("output/flows")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	key := connectionKey(ss.net, ss.transport)
+	name := fmt.Sprintf("%v_%v.pcapng", flowFileNames.Replace(key), ss.flowID)
+	path := filepath.Join(dir, name)
+
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	options := pcapgo.DefaultNgWriterOptions
+	options.SectionInfo.Comment = flowFileComment(ss, key)
+
+	// the section and interface blocks are written by pcapgo, packet blocks are written by the flow file, as they carry comments
+	ng, err := newNgWriter(f, pcapgo.NgInterface{
+		Name:                ss.iface,
+		SnapLength:          uint32(snaplen),
+		TimestampResolution: 6,
+	}, linkType, options)
+	if err == nil {
+		err = ng.Flush()
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	ff := &flowFile{
+		path:  path,
+		f:     f,
+		w:     bufio.NewWriter(f),
+		first: 1,
+	}
+
+	openFlows.mu.Lock()
+	openFlows.files[ff] = true
+	openFlows.mu.Unlock()
+
+	log.Infof("writing flow %v to %v", ss.flowID, path)
+	return ff, nil
+}
+
+// protocol settings the flow was decoded with
+func flowFileComment(ss *shineStream, key string) string {
+	return fmt.Sprintf("shine flow %v %v\ninterface: %v\nagent: %v\nserver ports filter: %v\nserver side capture: %v\nxor limit: %v\ncommands: %v",
+		ss.flowID, key, ss.iface, ss.agent, filter, serverSideCapture, viper.GetString("protocol.xorLimit"), viper.GetString("protocol.commands"))
+}
+
+// queue a packet, returns its sequence number in the file, starting from 1
+func (ff *flowFile) write(ci gopacket.CaptureInfo, data []byte) (uint64, error) {
+	ff.mu.Lock()
+	defer ff.mu.Unlock()
+	if ff.closed {
+		return 0, nil
+	}
+
+	frame := &flowFrame{
+		ci:     ci,
+		data:   make([]byte, len(data)),
+		queued: time.Now(),
+	}
+	// data may be reused by the capture once Accept returns
+	copy(frame.data, data)
+
+	ff.pending = append(ff.pending, frame)
+	seq := ff.first + uint64(len(ff.pending)) - 1
+
+	return seq, ff.writeHeld(false)
+}
+
+// add a comment to a frame, ignored if it's already written
+func (ff *flowFile) annotate(seq uint64, comment string) {
+	ff.mu.Lock()
+	defer ff.mu.Unlock()
+	if ff.closed || seq < ff.first || seq >= ff.first+uint64(len(ff.pending)) {
+		ff.late++
+		return
+	}
+	frame := ff.pending[seq-ff.first]
+	frame.comments = append(frame.comments, comment)
+}
+
+// write frames that waited long enough, or every frame if all is set
+func (ff *flowFile) writeHeld(all bool) error {
+	n := 0
+	for _, frame := range ff.pending {
+		if !all && len(ff.pending)-n <= flowFrameMaxPending && time.Since(frame.queued) < flowFrameHold {
+			break
+		}
+		if err := ff.writeFrame(frame); err != nil {
+			return err
+		}
+		n++
+	}
+	ff.pending = ff.pending[n:]
+	ff.first += uint64(n)
+	return nil
+}
+
+// enhanced packet block, with an opt_comment for each comment
+func (ff *flowFile) writeFrame(frame *flowFrame) error {
+	var options []byte
+	for _, c := range frame.comments {
+		options = appendNgOption(options, 1, []byte(c))
+	}
+	if len(options) > 0 {
+		// opt_endofopt
+		options = appendNgOption(options, 0, nil)
+	}
+
+	dataLen := len(frame.data)
+	blockLen := 32 + ngPadded(dataLen) + len(options)
+
+	// microseconds, the resolution of the interface
+	ts := uint64(frame.ci.Timestamp.UnixNano() / 1000)
+
+	header := make([]byte, 28)
+	binary.LittleEndian.PutUint32(header[0:], 6)
+	binary.LittleEndian.PutUint32(header[4:], uint32(blockLen))
+	binary.LittleEndian.PutUint32(header[8:], 0)
+	binary.LittleEndian.PutUint32(header[12:], uint32(ts>>32))
+	binary.LittleEndian.PutUint32(header[16:], uint32(ts))
+	binary.LittleEndian.PutUint32(header[20:], uint32(dataLen))
+	binary.LittleEndian.PutUint32(header[24:], uint32(frame.ci.Length))
+
+	trailer := make([]byte, 4)
+	binary.LittleEndian.PutUint32(trailer, uint32(blockLen))
+
+	for _, b := range [][]byte{header, frame.data, make([]byte, ngPadded(dataLen)-dataLen), options, trailer} {
+		if _, err := ff.w.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// option code, length and value padded to 32 bits
+func appendNgOption(b []byte, code uint16, value []byte) []byte {
+	h := make([]byte, 4)
+	binary.LittleEndian.PutUint16(h, code)
+	binary.LittleEndian.PutUint16(h[2:], uint16(len(value)))
+	b = append(b, h...)
+	b = append(b, value...)
+	return append(b, make([]byte, ngPadded(len(value))-len(value))...)
+}
+
+func ngPadded(n int) int {
+	return (n + 3) &^ 3
+}
+
+func (ff *flowFile) close() {
+	ff.mu.Lock()
+	defer ff.mu.Unlock()
+	if ff.closed {
+		return
+	}
+	ff.closed = true
+
+	openFlows.mu.Lock()
+	delete(openFlows.files, ff)
+	openFlows.mu.Unlock()
+
+	if err := ff.writeHeld(true); err != nil {
+		log.Errorf("error writing %v: %v", ff.path, err)
+	}
+	if err := ff.w.Flush(); err != nil {
+		log.Errorf("error writing %v: %v", ff.path, err)
+	}
+	if err := ff.f.Close(); err != nil {
+		log.Error(err)
+	}
+	if ff.late > 0 {
+		log.Warningf("%v frames of %v were written before they could be annotated", ff.late, ff.path)
+	}
+}
+
+// close every flow file that is still open
+func closeFlowFiles() {
+	openFlows.mu.Lock()
+	var files []*flowFile
+	for ff := range openFlows.files {
+		files = append(files, ff)
+	}
+	openFlows.mu.Unlock()
+
+	for _, ff := range files {
+		ff.close()
+	}
+}
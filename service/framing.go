@@ -0,0 +1,21 @@
+package service
+
+import "encoding/binary"
+
+// frame the shine packet at offset, its length is in the first byte, or in the next two bytes if the first one is 0
+// data[start:end] is the packet after its length, end is past len(data) while the packet isn't complete
+// ok is false if data ends before the length does
+func packetBounds(data []byte, offset int) (start, end int, ok bool) {
+	if offset >= len(data) {
+		return 0, 0, false
+	}
+	if data[offset] != 0 {
+		start = offset + 1
+		return start, start + int(data[offset]), true
+	}
+	if len(data)-offset < 3 {
+		return 0, 0, false
+	}
+	start = offset + 3
+	return start, start + int(binary.LittleEndian.Uint16(data[offset+1:])), true
+}
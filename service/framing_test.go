@@ -0,0 +1,33 @@
+package service
+
+import "testing"
+
+func TestPacketBounds(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   []byte
+		offset int
+		start  int
+		end    int
+		ok     bool
+	}{
+		{"small packet", []byte{3, 0x07, 0x08, 0x01}, 0, 1, 4, true},
+		{"incomplete small packet", []byte{3, 0x07}, 0, 1, 4, true},
+		{"big packet", append([]byte{0, 0x2c, 0x01}, make([]byte, 300)...), 0, 3, 303, true},
+		{"incomplete big packet", []byte{0, 0x2c, 0x01, 0x07}, 0, 3, 303, true},
+		{"at an offset", []byte{2, 0x07, 0x08, 0, 4, 0}, 3, 6, 10, true},
+		{"big length not complete", []byte{2, 0x07, 0x08, 0, 4}, 3, 0, 0, false},
+		{"only the big packet marker", []byte{0}, 0, 0, 0, false},
+		{"empty packet", []byte{0, 0, 0}, 0, 3, 3, true},
+		{"end of data", []byte{2, 0x07, 0x08}, 3, 0, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, end, ok := packetBounds(tt.data, tt.offset)
+			if start != tt.start || end != tt.end || ok != tt.ok {
+				t.Errorf("got %v %v %v, want %v %v %v", start, end, ok, tt.start, tt.end, tt.ok)
+			}
+		})
+	}
+}
@@ -6,9 +6,11 @@ import (
 	"encoding/binary"
 	"encoding/hex"
 	"fmt"
+	"github.com/google/gopacket"
 	"github.com/segmentio/ksuid"
 	"github.com/shine-o/shine.engine.core/networking"
 	"github.com/spf13/viper"
+	"net"
 	"time"
 )
 
@@ -16,196 +18,545 @@ type shineSegment struct {
 	data      []byte
 	seen      time.Time
 	direction string
+	// frame of the flow file that completed the segment, 0 if it isn't written to one
+	frame uint64
+	// bytes of the stream missing before the segment, -1 if it's unknown how many
+	gap int
+	// position of the segment in its direction, segments dropped by backpressure leave a hole
+	index uint64
 }
 
 type decodedPacket struct {
 	seen      time.Time
-	packet   * networking.Command
+	packet    *networking.Command
 	direction string
+	// position of the packet in the stream, both directions merged
+	seq uint64
 }
 
 // handle stream data flowing from the client
-func (ss *shineStream) decodeClientPackets(ctx context.Context, segments <-chan shineSegment, xorKeyFound <-chan bool, xorKey <-chan uint16) {
+func (ss *shineStream) decodeClientPackets(ctx context.Context, segments <-chan shineSegment, xorKey <-chan uint16) {
 	var (
-		data       []byte
-		offset     int
-		xorOffset  uint16
-		hasXorKey  bool
-		shouldQuit bool
+		xorOffset    uint16
+		hasXorKey    bool
+		segmentsDone bool
+		seen         time.Time
+		direction    string
+		frame        uint64
+		// set when the framing is lost, until a packet boundary is found again
+		resync    bool
+		gapStart  int64
+		gapReason string
+		// set once bytes of a ciphered stream were lost, the offset of NC_MISC_SEED_ACK doesn't apply after them
+		keyLost bool
+		// index of the next segment, to find the ones backpressure dropped
+		next uint64
 	)
-	offset = 0
+	b := ss.clientBuffer
+	defer b.release()
 	logActivated := viper.GetBool("protocol.log.client")
+	ciphered := !serverSideCapture
+
+	// the server side decoder is done with the seed, ok is false if it had none
+	seed := func(x uint16, ok bool) {
+		xorKey = nil
+		if ok && hasXorKey {
+			log.Info("xor key found, offset was already recovered")
+		} else if ok && keyLost {
+			log.Info("xor key found, but bytes were lost since, the offset is recovered from the packets instead")
+		} else if ok {
+			log.Info("xor key found")
+			xorOffset = x
+			hasXorKey = true
+		}
+	}
 
-loop:
-	for {
-		select {
-		case <-ctx.Done():
-			log.Warningf("[%v %v] decodeClientPackets(): context was canceled", ss.net, ss.transport)
-			shouldQuit = true
-			return
-		case <-xorKeyFound:
-			log.Info("xor key found, waiting for it in a select")
-			for {
-				select {
-				// retrial mechanism so it doesn't end up in infinite loop
-				case xorOffset = <-xorKey:
+	// decode as many packets as are available in the buffered data
+	decode := func() {
+		for b.offset < len(b.data) {
+			if resync {
+				if ciphered && !hasXorKey {
+					pos, x, found := resyncUnkeyed(b.data[b.offset:], maxClientPacketLength)
+					b.offset += pos
+					if !found {
+						return
+					}
+					log.Warningf("[%v %v] xor offset %v recovered after a gap", ss.net, ss.transport, x)
+					xorOffset = x
 					hasXorKey = true
-					continue loop
+				} else {
+					pos, found := resyncPacket(b.data[b.offset:], maxClientPacketLength)
+					b.offset += pos
+					if !found {
+						return
+					}
 				}
+				resync = false
+				ss.reportGap(direction, gapStart, b.base+int64(b.offset), gapReason)
 			}
-		case segment := <-segments:
-			data = append(data, segment.data...)
 
-			if offset >= len(data) {
-				log.Warningf("not enough data, next offset is %v ", offset)
-				break
+			if ciphered && !hasXorKey && xorKey != nil {
+				// the seed may be on its way, the offset is only recovered once the server side is known to have none
+				select {
+				case x, ok := <-xorKey:
+					seed(x, ok)
+				default:
+					return
+				}
 			}
 
-			for offset < len(data) {
-				if !serverSideCapture {
-					if !hasXorKey {
-						break
-					}
+			if ciphered && !hasXorKey {
+				// the seed was sent before the capture started, the buffered packets are decoded once the offset is found
+				x, ok, _ := recoverXorOffset(b.data[b.offset:])
+				if !ok {
+					return
 				}
+				log.Warningf("[%v %v] xor offset %v recovered from %v buffered bytes", ss.net, ss.transport, x, b.pending())
+				xorOffset = x
+				hasXorKey = true
+			}
+
+			start, end, ok := packetBounds(b.data, b.offset)
+			if !ok {
+				return
+			}
+			pLen := end - start
 
-				var skipBytes int
-				var pLen uint16
+			if pLen > maxClientPacketLength {
+				log.Errorf("bad length value %v", pLen)
+				if ringCapture.badLength {
+					ss.triggerRing(fmt.Sprintf("bad length value %v", pLen))
+				}
+				resync = true
+				gapStart = b.base + int64(b.offset)
+				gapReason = fmt.Sprintf("bad length value %v", pLen)
+				// the length itself is garbage, look for the next packet after it
+				b.offset++
+				if ciphered {
+					ss.dropXorKey()
+					hasXorKey = false
+					keyLost = true
+				}
+				continue
+			}
 
-				pLen, skipBytes = networking.PacketBoundary(offset, data)
+			if end > len(b.data) {
+				log.Warningf("not enough data, next offset is %v ", end)
+				return
+			}
 
-				nextOffset := offset + skipBytes + int(pLen)
+			packetData := make([]byte, pLen)
 
-				if nextOffset > len(data) {
-					log.Warningf("not enough data, next offset is %v ", nextOffset)
-					break
-				}
+			copy(packetData, b.data[start:end])
 
-				if pLen == uint16(65535) {
-					log.Errorf("bad length value %v", pLen)
-					return
-				}
+			if ciphered {
+				networking.XorCipher(packetData, &xorOffset)
+			}
 
-				packetData := make([]byte, pLen)
+			p, _ := networking.DecodePacket(packetData)
 
-				copy(packetData, data[offset+skipBytes:nextOffset])
+			ss.annotateFrame(frame, &p, direction)
+			ss.checkTriggers(&p)
+			ss.trackSession(&p, seen)
 
-				if !serverSideCapture {
-					networking.XorCipher(packetData, &xorOffset)
-				}
+			if logActivated {
+				ss.queuePacket(decodedPacket{
+					seen:      seen,
+					packet:    &p,
+					direction: direction,
+				})
+			}
+			b.offset = end
+		}
+	}
 
-				p, _ := networking.DecodePacket(packetData)
+	// buffer a segment and decode what it completes
+	apply := func(segment shineSegment) {
+		seen = segment.seen
+		direction = segment.direction
+		frame = segment.frame
 
-				if logActivated {
-					ss.packets <- decodedPacket{
-						seen:      segment.seen,
-						packet:    &p,
-						direction: segment.direction,
-					}
+		if segment.gap != 0 {
+			if !resync {
+				gapStart = b.base + int64(b.offset)
+			}
+			lost := 0
+			if segment.gap > 0 {
+				lost = segment.gap
+				gapReason = fmt.Sprintf("%v bytes were not captured", lost)
+			} else {
+				gapReason = "capture started in the middle of the stream"
+			}
+			b.skip(lost)
+			resync = true
+			if ciphered {
+				ss.dropXorKey()
+				hasXorKey = false
+				keyLost = true
+			}
+		}
+
+		b.append(segment.data)
+		decode()
+		b.compact()
+	}
+
+	// segments that waited on disk, as long as there is room for them
+	unspill := func(all bool) {
+		for {
+			segment, ok := b.unspill(all)
+			if !ok {
+				return
+			}
+			segment.direction = "outbound"
+			apply(segment)
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Warningf("[%v %v] decodeClientPackets(): context was canceled", ss.net, ss.transport)
+			return
+		case x, ok := <-xorKey:
+			seed(x, ok)
+			// buffered packets are decoded with the seed, or with the offset recovered from them
+			decode()
+			b.compact()
+			unspill(false)
+			if segmentsDone {
+				unspill(true)
+				return
+			}
+		case segment, ok := <-segments:
+			if !ok {
+				// the stream is complete, but the xor offset may still be on its way
+				if ciphered && !hasXorKey && xorKey != nil {
+					segmentsDone = true
+					segments = nil
+					break
 				}
-				offset += skipBytes + int(pLen)
+				unspill(true)
+				return
 			}
-			if shouldQuit {
+			ss.catchUp(&segment, &next, 0)
+
+			switch b.admit(ctx, segment) {
+			case admitted:
+				apply(segment)
+			case dropped:
+				log.Errorf("[%v %v] client buffer is over its limit, the stream won't be decoded anymore", ss.net, ss.transport)
 				return
 			}
+			unspill(false)
 		}
 	}
 }
 
 // handle stream data flowing from the server
-func (ss *shineStream) decodeServerPackets(ctx context.Context, segments <-chan shineSegment, xorKeyFound chan<- bool, xorKey chan<- uint16) {
+func (ss *shineStream) decodeServerPackets(ctx context.Context, segments <-chan shineSegment, xorKey chan<- uint16) {
 	var (
-		data           []byte
-		offset         int
-		xorOffsetFound bool
-		shouldQuit     bool
+		// set when the framing is lost, until a packet boundary is found again
+		resync    bool
+		gapStart  int64
+		gapReason string
+		next      uint64
+		// set once the seed was sent, or can't be anymore
+		seedDone bool
 	)
-	xorOffsetFound = false
-	offset = 0
+	b := ss.serverBuffer
+	defer b.release()
+
+	// NC_MISC_SEED_ACK is the first packet the server sends, the client side decoder waits for it until the channel is closed
+	noSeed := func() {
+		if !seedDone {
+			seedDone = true
+			close(xorKey)
+		}
+	}
+	defer noSeed()
 
 	logActivated := viper.GetBool("protocol.log.server")
-	for {
-		select {
-		case <-ctx.Done():
-			log.Warningf("[%v %v] decodeServerPackets(): context was canceled", ss.net, ss.transport)
-			shouldQuit = true
-			return
-		case segment := <-segments:
-			data = append(data, segment.data...)
-			if offset >= len(data) {
-				log.Warningf("not enough data, next offset is %v ", offset)
-				break
+
+	// decode as many packets as are available in the buffered data
+	decode := func(segment shineSegment) {
+		for b.offset < len(b.data) {
+			if resync {
+				pos, found := resyncPacket(b.data[b.offset:], maxServerPacketLength)
+				b.offset += pos
+				if !found {
+					return
+				}
+				resync = false
+				ss.reportGap(segment.direction, gapStart, b.base+int64(b.offset), gapReason)
 			}
 
-			for offset < len(data) {
-				var skipBytes int
-				var pLen uint16
+			start, end, ok := packetBounds(b.data, b.offset)
+			if !ok {
+				return
+			}
+			pLen := end - start
 
-				pLen, skipBytes = networking.PacketBoundary(offset, data)
+			if pLen > maxServerPacketLength {
+				log.Errorf("bad length value %v", pLen)
+				if ringCapture.badLength {
+					ss.triggerRing(fmt.Sprintf("bad length value %v", pLen))
+				}
+				resync = true
+				gapStart = b.base + int64(b.offset)
+				gapReason = fmt.Sprintf("bad length value %v", pLen)
+				// the length itself is garbage, look for the next packet after it
+				b.offset++
+				continue
+			}
 
-				nextOffset := offset + skipBytes + int(pLen)
+			if end > len(b.data) {
+				log.Warningf("not enough data for stream %v, next offset is %v ", ss.transport, end)
+				return
+			}
 
-				if nextOffset > len(data) {
-					log.Warningf("not enough data for stream %v, next offset is %v ", ss.transport, nextOffset)
-					break
-				}
+			packetData := make([]byte, pLen)
 
-				if pLen > uint16(32767) {
-					log.Errorf("bad length value %v", pLen)
-					return
-				}
+			copy(packetData, b.data[start:end])
 
-				packetData := make([]byte, pLen)
-
-				copy(packetData, data[offset+skipBytes:nextOffset])
-
-				pc, _ := networking.DecodePacket(packetData)
-
-				if !serverSideCapture {
-					if !xorOffsetFound {
-						log.Info("xor offset not found")
-						if pc.Base.OperationCode == 2055 {
-							var xorOffset uint16
-							buf := bytes.NewBuffer(pc.Base.Data)
-							if err := binary.Read(buf, binary.LittleEndian, &xorOffset); err != nil {
-								log.Error(err)
-								return
-							}
-							xorOffsetFound = true
-							xorKeyFound <- true
-							xorKey <- xorOffset
-						}
-					}
-				}
+			pc, _ := networking.DecodePacket(packetData)
 
-				if logActivated {
-					ss.packets <- decodedPacket{
-						seen:      segment.seen,
-						packet:    &pc,
-						direction: segment.direction,
+			ss.annotateFrame(segment.frame, &pc, segment.direction)
+			ss.checkTriggers(&pc)
+			ss.trackSession(&pc, segment.seen)
+
+			if !serverSideCapture && !seedDone {
+				if pc.Base.OperationCode == 2055 {
+					var xorOffset uint16
+					buf := bytes.NewBuffer(pc.Base.Data)
+					if err := binary.Read(buf, binary.LittleEndian, &xorOffset); err != nil {
+						log.Error(err)
+					} else {
+						xorKey <- xorOffset
 					}
+				} else {
+					log.Info("xor offset not found")
 				}
-				offset += skipBytes + int(pLen)
 			}
+			noSeed()
+
+			if logActivated {
+				ss.queuePacket(decodedPacket{
+					seen:      segment.seen,
+					packet:    &pc,
+					direction: segment.direction,
+				})
+			}
+			b.offset = end
+		}
+	}
+
+	// buffer a segment and decode what it completes
+	apply := func(segment shineSegment) {
+		if segment.gap != 0 {
+			if !resync {
+				gapStart = b.base + int64(b.offset)
+			}
+			lost := 0
+			if segment.gap > 0 {
+				lost = segment.gap
+				gapReason = fmt.Sprintf("%v bytes were not captured", lost)
+			} else {
+				gapReason = "capture started in the middle of the stream"
+			}
+			b.skip(lost)
+			resync = true
+			// the seed may be in the bytes that weren't captured
+			noSeed()
+		}
+
+		b.append(segment.data)
+		decode(segment)
+		b.compact()
+	}
+
+	// segments that waited on disk, as long as there is room for them
+	unspill := func(all bool) {
+		for {
+			segment, ok := b.unspill(all)
+			if !ok {
+				return
+			}
+			segment.direction = "inbound"
+			apply(segment)
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Warningf("[%v %v] decodeServerPackets(): context was canceled", ss.net, ss.transport)
+			return
+		case segment, ok := <-segments:
+			if !ok {
+				unspill(true)
+				return
+			}
+			ss.catchUp(&segment, &next, 1)
 
-			if shouldQuit {
+			switch b.admit(ctx, segment) {
+			case admitted:
+				apply(segment)
+			case dropped:
+				log.Errorf("[%v %v] server buffer is over its limit, the stream won't be decoded anymore", ss.net, ss.transport)
 				return
 			}
+			unspill(false)
 		}
 	}
 }
 
+// discard segments a decoder gave up on, so the assembler never blocks sending them
+func drainSegments(ctx context.Context, segments <-chan shineSegment) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case _, ok := <-segments:
+			if !ok {
+				return
+			}
+		}
+	}
+}
+
+// orderedPacket a decoded packet waiting for the other direction, so both can be merged by capture time
+type orderedPacket struct {
+	dp       decodedPacket
+	received time.Time
+}
+
+// preparedPacket a packet whose view is being built, results arrive in the order packets were sent for preparation
+type preparedPacket struct {
+	dp decodedPacket
+	pv chan PacketView
+}
+
+// merge both directions by capture time, then build the views in parallel and log them in order
+// each direction is already in tcp sequence order, a packet is held until the other direction has one to compare it with,
+// or for protocol.order.hold if the other direction is quiet
 func (ss *shineStream) handleDecodedPackets(ctx context.Context, decodedPackets <-chan decodedPacket) {
+	hold := viper.GetDuration("protocol.order.hold")
+	workers := viper.GetInt("protocol.order.workers")
+	if workers < 1 {
+		workers = 1
+	}
+
+	prepared := make(chan preparedPacket, workers)
+	emitted := make(chan struct{})
+	go func() {
+		defer close(emitted)
+		for pp := range prepared {
+			ss.emitPacket(pp.dp, <-pp.pv)
+		}
+	}()
+	defer func() {
+		close(prepared)
+		<-emitted
+	}()
+
+	var (
+		client, server []orderedPacket
+		seq            uint64
+	)
+
+	send := func(dp decodedPacket) bool {
+		seq++
+		dp.seq = seq
+		pp := preparedPacket{
+			dp: dp,
+			pv: make(chan PacketView, 1),
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case prepared <- pp:
+		}
+		go func() {
+			pp.pv <- ss.preparePacket(dp)
+		}()
+		return true
+	}
+
+	// send every packet that can't be preceded by one that is yet to come, all of them if flush is set
+	release := func(flush bool) bool {
+		for len(client) > 0 || len(server) > 0 {
+			var next *[]orderedPacket
+			switch {
+			case len(client) > 0 && len(server) > 0:
+				// requests go first when they were captured at the same time
+				if server[0].dp.seen.Before(client[0].dp.seen) {
+					next = &server
+				} else {
+					next = &client
+				}
+			case len(client) > 0:
+				next = &client
+			default:
+				next = &server
+			}
+			if !flush && (len(client) == 0 || len(server) == 0) {
+				if time.Since((*next)[0].received) < hold {
+					return true
+				}
+			}
+			dp := (*next)[0].dp
+			*next = (*next)[1:]
+			if !send(dp) {
+				return false
+			}
+		}
+		return true
+	}
+
 	for {
+		var wait <-chan time.Time
+		if len(client) > 0 || len(server) > 0 {
+			wait = time.After(hold)
+		}
+
 		select {
 		case <-ctx.Done():
 			return
-		case dp := <-decodedPackets:
-			go ss.logPacket(dp)
+		case <-wait:
+			if !release(false) {
+				return
+			}
+		case dp, ok := <-decodedPackets:
+			if !ok {
+				release(true)
+				return
+			}
+			op := orderedPacket{
+				dp:       dp,
+				received: time.Now(),
+			}
+			if dp.direction == "outbound" {
+				client = append(client, op)
+			} else {
+				server = append(server, op)
+			}
+			if !release(false) {
+				return
+			}
 		}
 	}
 }
 
-func (ss *shineStream) logPacket(dp decodedPacket) {
+// connectionKey identifies a connection by its endpoints, e.g: 10.0.0.2:50000->10.0.0.1:9010 or [::1]:50000->[::1]:9010
+func connectionKey(netFlow, transport gopacket.Flow) string {
+	src := net.JoinHostPort(netFlow.Src().String(), transport.Src().String())
+	dst := net.JoinHostPort(netFlow.Dst().String(), transport.Dst().String())
+	return src + "->" + dst
+}
+
+// build the view of a packet, structs are decoded here so it can run in parallel
+func (ss *shineStream) preparePacket(dp decodedPacket) PacketView {
 	packetID, err := ksuid.NewRandomWithTime(dp.seen)
 	if err != nil {
 		log.Error(err)
@@ -214,11 +565,15 @@ func (ss *shineStream) logPacket(dp decodedPacket) {
 
 	pv := PacketView{
 		PacketID:      packetID.String(),
+		Sequence:      dp.seq,
 		TimeStamp:     dp.seen.String(),
 		IPEndpoints:   ss.net.String(),
 		PortEndpoints: ss.transport.String(),
+		Interface:     ss.iface,
+		Agent:         ss.agent,
 		Direction:     dp.direction,
 		PacketData:    dp.packet.Base.JSON(),
+		SessionID:     ss.sessionID(),
 	}
 
 	nr, err := ncStructRepresentation(dp.packet.Base.OperationCode, dp.packet.Base.Data)
@@ -230,6 +585,12 @@ func (ss *shineStream) logPacket(dp decodedPacket) {
 		//log.Error(err)
 	}
 
+	pv.ConnectionKey = connectionKey(ss.net, ss.transport)
+	return pv
+}
+
+// log a packet and send it to the ui, packets of a stream are emitted one at a time, in sequence order
+func (ss *shineStream) emitPacket(dp decodedPacket, pv PacketView) {
 	var tPorts string
 
 	if dp.direction == "inbound" {
@@ -239,16 +600,15 @@ func (ss *shineStream) logPacket(dp decodedPacket) {
 	}
 
 	if viper.GetBool("protocol.log.verbose") {
-		log.Infof("\n%v\n%v\n%v\n%v\n%v\nunpacked data: %v \n%v", dp.packet.Base.ClientStructName, dp.seen, tPorts, dp.direction, dp.packet.Base.String(), pv.NcRepresentation.UnpackedData, hex.Dump(dp.packet.Base.Data))
+		log.Infof("\n#%v %v\n%v\nsession %v\n%v\n%v\n%v\nunpacked data: %v \n%v", pv.Sequence, dp.packet.Base.ClientStructName, dp.seen, pv.SessionID, tPorts, dp.direction, dp.packet.Base.String(), pv.NcRepresentation.UnpackedData, hex.Dump(dp.packet.Base.Data))
 	} else {
-		log.Infof("%v %v %v %v %v", dp.seen, tPorts, dp.direction,dp.packet.Base.ClientStructName, dp.packet.Base.String())
+		log.Infof("#%v %v %v %v %v %v %v", pv.Sequence, dp.seen, pv.SessionID, tPorts, dp.direction, dp.packet.Base.ClientStructName, dp.packet.Base.String())
 	}
 
-	pv.ConnectionKey = fmt.Sprintf("%v %v", ss.net.String(), ss.transport.String())
 	ocs.mu.Lock()
 	ocs.structs[dp.packet.Base.OperationCode] = dp.packet.Base.ClientStructName
 	ocs.mu.Unlock()
 
-	persistMovement(dp)
-	sendPacketToUI(pv)
+	persistMovement(dp, pv.SessionID)
+	ss.queueUI(pv)
 }
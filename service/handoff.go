@@ -0,0 +1,239 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"github.com/shine-o/shine.engine.core/structs"
+	"github.com/spf13/viper"
+	"gopkg.in/restruct.v1"
+	"net"
+	"reflect"
+	"strconv"
+	"sync"
+)
+
+// handoffField a server packet that sends the client to another server, unpacked with a struct of shine.engine.core
+// the address is the first Name4 field of the struct, the ip, and the uint16 field right after it, the port
+type handoffField struct {
+	OpCode uint16 `mapstructure:"opCode"`
+	Struct string `mapstructure:"struct"`
+	// found in the struct when the fields are loaded
+	t        reflect.Type
+	ip, port int
+}
+
+const handoffIPLen = 16
+
+var defaultHandoffFields = []handoffField{
+	{
+		// NC_USER_WORLDSELECT_ACK
+		OpCode: 3084,
+		Struct: "NcUserWorldSelectAck",
+	},
+	{
+		// NC_CHAR_LOGIN_ACK
+		OpCode: 4099,
+		Struct: "NcCharLoginAck",
+	},
+	{
+		// NC_MAP_LINKOTHER_CMD
+		// NC_MAP_LINKSAME_CMD (6153) only has the map and location, the client stays on the same zone server
+		OpCode: 6154,
+		Struct: "NcMapLinkOtherCmd",
+	},
+}
+
+var name4Type = reflect.TypeOf(structs.Name4{})
+
+// handoffProxy opens a local listener for every server the client is sent to, so the whole session goes through the proxy
+type handoffProxy struct {
+	ctx           context.Context
+	sf            *shineStreamFactory
+	advertiseHost string
+	listenHost    string
+	fields        map[uint16]handoffField
+	// applied to the connections of the servers clients are sent to too
+	rules packetRules
+	// local port for each upstream address
+	listeners map[string]int
+	mu        sync.Mutex
+}
+
+// nil if handoff rewriting is not configured
+func newHandoffProxy(ctx context.Context, sf *shineStreamFactory) (*handoffProxy, error) {
+	advertiseHost := viper.GetString("proxy.advertiseHost")
+	if advertiseHost == "" {
+		return nil, nil
+	}
+
+	if ip := net.ParseIP(advertiseHost); ip == nil || ip.To4() == nil || len(advertiseHost) >= handoffIPLen {
+		return nil, fmt.Errorf("proxy.advertiseHost must be an ipv4 address, got %v", advertiseHost)
+	}
+
+	fields, err := loadHandoffFields()
+	if err != nil {
+		return nil, err
+	}
+
+	hp := &handoffProxy{
+		ctx:           ctx,
+		sf:            sf,
+		advertiseHost: advertiseHost,
+		listenHost:    viper.GetString("proxy.listenHost"),
+		fields:        make(map[uint16]handoffField),
+		listeners:     make(map[string]int),
+	}
+
+	for _, f := range fields {
+		hp.fields[f.OpCode] = f
+	}
+	return hp, nil
+}
+
+// proxy.handoff, or the default fields if it isn't set
+// a struct without an address fails the load, so a layout change in shine.engine.core doesn't corrupt packets
+func loadHandoffFields() ([]handoffField, error) {
+	fields := append([]handoffField{}, defaultHandoffFields...)
+	if viper.IsSet("proxy.handoff") {
+		fields = nil
+		if err := viper.UnmarshalKey("proxy.handoff", &fields); err != nil {
+			return nil, err
+		}
+	}
+	for i := range fields {
+		if err := fields[i].resolve(); err != nil {
+			return nil, err
+		}
+	}
+	return fields, nil
+}
+
+// find the struct of the packet and its address fields
+func (f *handoffField) resolve() error {
+	t, ok := coreStructs[f.Struct]
+	if !ok || t.Kind() != reflect.Struct {
+		return fmt.Errorf("handoff packet %v: shine.engine.core has no struct %v", f.OpCode, f.Struct)
+	}
+	for i := 0; i+1 < t.NumField(); i++ {
+		if t.Field(i).Type == name4Type && t.Field(i+1).Type.Kind() == reflect.Uint16 {
+			f.t, f.ip, f.port = t, i, i+1
+			return nil
+		}
+	}
+	return fmt.Errorf("handoff packet %v: struct %v has no Name4 ip followed by a uint16 port", f.OpCode, f.Struct)
+}
+
+// nc is the packet data after the operation code
+func (f handoffField) unpack(nc []byte) (reflect.Value, error) {
+	v := reflect.New(f.t)
+	if err := restruct.Unpack(nc, binary.LittleEndian, v.Interface()); err != nil {
+		return reflect.Value{}, fmt.Errorf("handoff packet %v can't be unpacked with %v, length %v: %v", f.OpCode, f.Struct, len(nc), err)
+	}
+	return v.Elem(), nil
+}
+
+func (f handoffField) addressOf(v reflect.Value) string {
+	name := v.Field(f.ip).Interface().(structs.Name4).Name
+	ip := name[:]
+	if i := bytes.IndexByte(ip, 0); i >= 0 {
+		ip = ip[:i]
+	}
+	return net.JoinHostPort(string(ip), strconv.FormatUint(v.Field(f.port).Uint(), 10))
+}
+
+// server address a handoff packet sends the client to
+func (f handoffField) address(nc []byte) (string, error) {
+	v, err := f.unpack(nc)
+	if err != nil {
+		return "", err
+	}
+	return f.addressOf(v), nil
+}
+
+// local port clients should connect to instead of upstream, a listener is opened the first time
+// the upstream port is tried first, in case the client only allows the usual ports
+func (hp *handoffProxy) listenerFor(upstream string) (int, error) {
+	hp.mu.Lock()
+	defer hp.mu.Unlock()
+
+	if port, ok := hp.listeners[upstream]; ok {
+		return port, nil
+	}
+
+	_, upstreamPort, err := net.SplitHostPort(upstream)
+	if err != nil {
+		return 0, err
+	}
+
+	l, err := net.Listen("tcp", net.JoinHostPort(hp.listenHost, upstreamPort))
+	if err != nil {
+		l, err = net.Listen("tcp", net.JoinHostPort(hp.listenHost, "0"))
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	port := l.Addr().(*net.TCPAddr).Port
+	hp.listeners[upstream] = port
+
+	go func() {
+		<-hp.ctx.Done()
+		if err := l.Close(); err != nil {
+			log.Error(err)
+		}
+	}()
+	go acceptClients(hp.ctx, l, upstream, hp.sf, hp, hp.rules)
+
+	log.Infof("proxying handoff %v => %v", l.Addr(), upstream)
+	return port, nil
+}
+
+// rewrite the address in a handoff packet, so it points to the proxy
+// data is the packet without its length header, and is modified in place
+// the rewritten packet has the same length, so the framing of the stream doesn't change
+func (hp *handoffProxy) rewrite(data []byte) {
+	if len(data) < 2 {
+		return
+	}
+
+	opCode := binary.LittleEndian.Uint16(data)
+	f, ok := hp.fields[opCode]
+	if !ok {
+		return
+	}
+
+	nc := data[2:]
+	v, err := f.unpack(nc)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	upstream := f.addressOf(v)
+
+	port, err := hp.listenerFor(upstream)
+	if err != nil {
+		log.Errorf("could not proxy handoff to %v: %v", upstream, err)
+		return
+	}
+
+	var ip structs.Name4
+	copy(ip.Name[:], hp.advertiseHost)
+	v.Field(f.ip).Set(reflect.ValueOf(ip))
+	v.Field(f.port).SetUint(uint64(port))
+
+	packed, err := restruct.Pack(binary.LittleEndian, v.Addr().Interface())
+	if err != nil {
+		log.Errorf("could not repack handoff packet %v: %v", opCode, err)
+		return
+	}
+	if len(packed) > len(nc) {
+		log.Errorf("repacked handoff packet %v is longer than the original, %v > %v bytes", opCode, len(packed), len(nc))
+		return
+	}
+	// bytes after the struct are kept as they are
+	copy(nc, packed)
+
+	log.Infof("rewrote handoff in packet %v, %v => %v:%v", opCode, upstream, hp.advertiseHost, port)
+}
@@ -0,0 +1,192 @@
+package service
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+
+	"github.com/shine-o/shine.engine.core/structs"
+	"gopkg.in/restruct.v1"
+)
+
+func resolvedHandoffFields(t *testing.T) map[uint16]handoffField {
+	t.Helper()
+	fields := make(map[uint16]handoffField)
+	for _, f := range defaultHandoffFields {
+		if err := f.resolve(); err != nil {
+			t.Fatal(err)
+		}
+		fields[f.OpCode] = f
+	}
+	return fields
+}
+
+// packet data after the operation code with the address set, every other byte is 0xee
+func handoffData(t *testing.T, f handoffField, ip string, port uint16, trailing int) []byte {
+	t.Helper()
+	zero, err := restruct.Pack(binary.LittleEndian, reflect.New(f.t).Interface())
+	if err != nil {
+		t.Fatal(err)
+	}
+	nc := bytes.Repeat([]byte{0xee}, len(zero)+trailing)
+
+	v := reflect.New(f.t)
+	if err := restruct.Unpack(nc, binary.LittleEndian, v.Interface()); err != nil {
+		t.Fatal(err)
+	}
+	var name structs.Name4
+	copy(name.Name[:], ip)
+	v.Elem().Field(f.ip).Set(reflect.ValueOf(name))
+	v.Elem().Field(f.port).SetUint(uint64(port))
+	packed, err := restruct.Pack(binary.LittleEndian, v.Interface())
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(nc, packed)
+	return nc
+}
+
+// the offsets the address has in each packet, as the protocol defines them
+func TestHandoffFieldLayout(t *testing.T) {
+	fields := resolvedHandoffFields(t)
+
+	tests := []struct {
+		name       string
+		opCode     uint16
+		ipOffset   int
+		portOffset int
+	}{
+		{"NC_USER_WORLDSELECT_ACK", 3084, 1, 17},
+		{"NC_CHAR_LOGIN_ACK", 4099, 0, 16},
+		{"NC_MAP_LINKOTHER_CMD", 6154, 14, 30},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nc := handoffData(t, fields[tt.opCode], "10.20.30.40", 0xbeef, 0)
+			if got := bytes.Index(nc, []byte("10.20.30.40\x00")); got != tt.ipOffset {
+				t.Errorf("ip at %v, want %v", got, tt.ipOffset)
+			}
+			if got := bytes.Index(nc, []byte{0xef, 0xbe}); got != tt.portOffset {
+				t.Errorf("port at %v, want %v", got, tt.portOffset)
+			}
+		})
+	}
+}
+
+func TestHandoffFieldResolve(t *testing.T) {
+	tests := []struct {
+		name    string
+		field   handoffField
+		wantErr bool
+	}{
+		{"struct with an address", handoffField{OpCode: 4099, Struct: "NcCharLoginAck"}, false},
+		{"unknown struct", handoffField{OpCode: 4099, Struct: "NcCharLoginAckV2"}, true},
+		{"no struct", handoffField{OpCode: 4099}, true},
+		{"struct without an address", handoffField{OpCode: 2055, Struct: "NcMiscSeedAck"}, true},
+		{"not a struct", handoffField{OpCode: 12296, Struct: "NcItemDropAck"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := tt.field
+			if err := f.resolve(); (err != nil) != tt.wantErr {
+				t.Errorf("error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHandoffFieldAddress(t *testing.T) {
+	fields := resolvedHandoffFields(t)
+
+	tests := []struct {
+		name    string
+		field   handoffField
+		data    []byte
+		want    string
+		wantErr bool
+	}{
+		{
+			name:  "NC_USER_WORLDSELECT_ACK",
+			field: fields[3084],
+			data:  handoffData(t, fields[3084], "192.168.1.10", 9110, 0),
+			want:  "192.168.1.10:9110",
+		},
+		{
+			name:  "NC_CHAR_LOGIN_ACK",
+			field: fields[4099],
+			data:  handoffData(t, fields[4099], "10.0.0.2", 9120, 0),
+			want:  "10.0.0.2:9120",
+		},
+		{
+			name:  "NC_MAP_LINKOTHER_CMD",
+			field: fields[6154],
+			data:  handoffData(t, fields[6154], "10.0.0.3", 9121, 0),
+			want:  "10.0.0.3:9121",
+		},
+		{
+			name:  "longest ip",
+			field: fields[4099],
+			data:  handoffData(t, fields[4099], "255.255.255.255", 65535, 0),
+			want:  "255.255.255.255:65535",
+		},
+		{
+			name:  "bytes after the struct",
+			field: fields[4099],
+			data:  handoffData(t, fields[4099], "10.0.0.2", 9120, 4),
+			want:  "10.0.0.2:9120",
+		},
+		{
+			name:    "too short for the port",
+			field:   fields[4099],
+			data:    make([]byte, 17),
+			wantErr: true,
+		},
+		{
+			name:    "too short for the ip",
+			field:   fields[3084],
+			data:    make([]byte, 10),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.field.address(tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// only the address changes, the length and every other byte stay the same
+func TestHandoffRewrite(t *testing.T) {
+	fields := resolvedHandoffFields(t)
+	hp := &handoffProxy{
+		advertiseHost: "127.0.0.1",
+		fields:        fields,
+		listeners: map[string]int{
+			"10.0.0.3:9121": 40001,
+		},
+	}
+
+	for op, f := range fields {
+		for _, trailing := range []int{0, 3} {
+			data := make([]byte, 2)
+			binary.LittleEndian.PutUint16(data, op)
+			want := append(append([]byte{}, data...), handoffData(t, f, "127.0.0.1", 40001, trailing)...)
+			data = append(data, handoffData(t, f, "10.0.0.3", 9121, trailing)...)
+
+			hp.rewrite(data)
+			if !bytes.Equal(data, want) {
+				t.Errorf("packet %v with %v bytes after the struct: got % x, want % x", op, trailing, data, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,102 @@
+// Command corestructs writes service/corestructs.go, the struct types of shine.engine.core/structs by name
+// run it with go generate ./service after updating shine.engine.core
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"log"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const structsPackage = "github.com/shine-o/shine.engine.core/structs"
+
+// the fields of the structs don't matter, only their names
+type noImports struct{}
+
+func (noImports) Import(path string) (*types.Package, error) {
+	return nil, fmt.Errorf("%v isn't loaded", path)
+}
+
+func main() {
+	out, err := exec.Command("go", "list", "-json", structsPackage).Output()
+	if err != nil {
+		log.Fatalf("could not find %v: %v", structsPackage, err)
+	}
+	var pkg struct {
+		Dir     string
+		GoFiles []string
+	}
+	if err := json.Unmarshal(out, &pkg); err != nil {
+		log.Fatal(err)
+	}
+
+	fset := token.NewFileSet()
+	var files []*ast.File
+	for _, name := range pkg.GoFiles {
+		f, err := parser.ParseFile(fset, filepath.Join(pkg.Dir, name), nil, 0)
+		if err != nil {
+			log.Fatal(err)
+		}
+		files = append(files, f)
+	}
+
+	conf := types.Config{
+		Importer: noImports{},
+		Error:    func(err error) {},
+	}
+	tp, _ := conf.Check(structsPackage, fset, files, nil)
+
+	var names []string
+	// zero value of each type, a conversion for the ones that aren't structs
+	values := make(map[string]string)
+	scope := tp.Scope()
+	for _, name := range scope.Names() {
+		tn, ok := scope.Lookup(name).(*types.TypeName)
+		if !ok || !tn.Exported() || tn.IsAlias() {
+			continue
+		}
+		switch tn.Type().Underlying().(type) {
+		case *types.Struct:
+			names = append(names, name)
+			values[name] = fmt.Sprintf("structs.%v{}", name)
+		case *types.Basic:
+			// packets that are a single value, like NcItemDropAck
+			if strings.HasPrefix(name, "Nc") {
+				names = append(names, name)
+				values[name] = fmt.Sprintf("structs.%v(0)", name)
+			}
+		}
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	buf.WriteString("// Code generated by go run ./internal/corestructs; DO NOT EDIT.\n\n")
+	buf.WriteString("package service\n\n")
+	buf.WriteString("import (\n\t\"github.com/shine-o/shine.engine.core/structs\"\n\t\"reflect\"\n)\n\n")
+	buf.WriteString("// coreStructs struct types of shine.engine.core, definitions in protocol.structs and command names refer to them by name\n")
+	buf.WriteString("var coreStructs = map[string]reflect.Type{\n")
+	for _, name := range names {
+		fmt.Fprintf(&buf, "\t%q: reflect.TypeOf(%v),\n", name, values[name])
+	}
+	buf.WriteString("}\n")
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := ioutil.WriteFile("corestructs.go", src, 0666); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("%v structs of %v", len(names), structsPackage)
+}
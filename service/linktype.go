@@ -0,0 +1,144 @@
+package service
+
+import (
+	"encoding/binary"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"io"
+)
+
+// LINKTYPE_LINUX_SLL2, captures on the linux "any" device with libpcap >= 1.10
+const linkTypeLinuxSLL2 = 276
+
+// layers.LinkType is a byte, so capture handles and files report LINKTYPE_LINUX_SLL2 as its low byte
+const truncatedLinuxSLL2 = layers.LinkType(linkTypeLinuxSLL2 & 0xff)
+
+const (
+	sllHeaderLen  = 16
+	sll2HeaderLen = 20
+)
+
+// capturedFrame a frame as it was captured, kept in the ancillary data of frames converted for decoding
+// so pcap files get the original header and link type
+type capturedFrame struct {
+	data     []byte
+	length   int
+	linkType uint16
+}
+
+// rewrite a linux cooked capture v2 header as a v1 header, which gopacket can decode
+// the interface index is dropped
+func sll2ToSLL(data []byte, ci gopacket.CaptureInfo) ([]byte, gopacket.CaptureInfo) {
+	if len(data) < sll2HeaderLen {
+		return data, ci
+	}
+	sll := make([]byte, len(data)-(sll2HeaderLen-sllHeaderLen))
+	// packet type
+	sll[1] = data[10]
+	// ARPHRD type
+	copy(sll[2:4], data[8:10])
+	// link layer address length and address
+	sll[5] = data[11]
+	copy(sll[6:14], data[12:20])
+	// protocol type
+	copy(sll[14:16], data[0:2])
+	copy(sll[sllHeaderLen:], data[sll2HeaderLen:])
+
+	ci.AncillaryData = append(ci.AncillaryData, capturedFrame{
+		data:     data,
+		length:   ci.Length,
+		linkType: linkTypeLinuxSLL2,
+	})
+	ci.CaptureLength -= sll2HeaderLen - sllHeaderLen
+	ci.Length -= sll2HeaderLen - sllHeaderLen
+	return sll, ci
+}
+
+// the frame as it was captured, if it was converted for decoding
+func (c *Context) restoreCaptured() {
+	for _, a := range c.ci.AncillaryData {
+		if f, ok := a.(capturedFrame); ok {
+			c.data = f.data
+			c.linkType = f.linkType
+			c.ci.CaptureLength = len(f.data)
+			c.ci.Length = f.length
+			return
+		}
+	}
+}
+
+type sll2Handle struct {
+	captureHandle
+}
+
+func (h sll2Handle) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	data, ci, err := h.captureHandle.ReadPacketData()
+	if err != nil {
+		return data, ci, err
+	}
+	data, ci = sll2ToSLL(data, ci)
+	return data, ci, nil
+}
+
+// the link type the frames are decoded as
+func (h sll2Handle) LinkType() layers.LinkType {
+	return layers.LinkTypeLinuxSLL
+}
+
+type sll2File struct {
+	packetFile
+}
+
+func (pf sll2File) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	data, ci, err := pf.packetFile.ReadPacketData()
+	if err != nil {
+		return data, ci, err
+	}
+	data, ci = sll2ToSLL(data, ci)
+	return data, ci, nil
+}
+
+func (pf sll2File) LinkType() layers.LinkType {
+	return layers.LinkTypeLinuxSLL
+}
+
+// pcapng writer whose interface has the link type frames were captured with
+// pcapgo writes link types as a byte, so it's set in the interface block as the header is written
+func newNgWriter(w io.Writer, intf pcapgo.NgInterface, linkType uint16, options pcapgo.NgWriterOptions) (*pcapgo.NgWriter, error) {
+	intf.LinkType = layers.LinkType(linkType)
+	return pcapgo.NewNgWriterInterface(&ngLinkTypeWriter{
+		w:        w,
+		linkType: linkType,
+	}, intf, options)
+}
+
+// ngLinkTypeWriter holds back the section header and the first interface block until the link type is set
+type ngLinkTypeWriter struct {
+	w        io.Writer
+	linkType uint16
+	head     []byte
+	done     bool
+}
+
+func (lw *ngLinkTypeWriter) Write(p []byte) (int, error) {
+	if lw.done {
+		return lw.w.Write(p)
+	}
+	lw.head = append(lw.head, p...)
+	if len(lw.head) < 8 {
+		return len(p), nil
+	}
+	// the interface block follows the section header block, its link type is after the block type and length
+	at := int(binary.LittleEndian.Uint32(lw.head[4:8])) + 8
+	if len(lw.head) < at+2 {
+		return len(p), nil
+	}
+	binary.LittleEndian.PutUint16(lw.head[at:], lw.linkType)
+	lw.done = true
+	if _, err := lw.w.Write(lw.head); err != nil {
+		return 0, err
+	}
+	lw.head = nil
+	return len(p), nil
+}
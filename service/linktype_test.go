@@ -0,0 +1,135 @@
+package service
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"testing"
+)
+
+func TestSLL2ToSLL(t *testing.T) {
+	payload := []byte{0x45, 0x00, 0x00, 0x14}
+	sll2 := []byte{
+		// protocol type
+		0x08, 0x00,
+		// reserved
+		0x00, 0x00,
+		// interface index
+		0x00, 0x00, 0x00, 0x03,
+		// ARPHRD type
+		0x00, 0x01,
+		// packet type
+		0x04,
+		// link layer address length and address
+		0x06, 0x02, 0x42, 0xac, 0x11, 0x00, 0x02, 0x00, 0x00,
+	}
+	frame := append(append([]byte{}, sll2...), payload...)
+
+	tests := []struct {
+		name string
+		data []byte
+		want []byte
+	}{
+		{
+			name: "frame",
+			data: frame,
+			want: append([]byte{
+				0x00, 0x04,
+				0x00, 0x01,
+				0x00, 0x06,
+				0x02, 0x42, 0xac, 0x11, 0x00, 0x02, 0x00, 0x00,
+				0x08, 0x00,
+			}, payload...),
+		},
+		{
+			name: "header only",
+			data: sll2,
+			want: []byte{
+				0x00, 0x04,
+				0x00, 0x01,
+				0x00, 0x06,
+				0x02, 0x42, 0xac, 0x11, 0x00, 0x02, 0x00, 0x00,
+				0x08, 0x00,
+			},
+		},
+		{
+			name: "truncated",
+			data: sll2[:12],
+			want: sll2[:12],
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ci := gopacket.CaptureInfo{
+				CaptureLength: len(tt.data),
+				Length:        len(tt.data) + 100,
+			}
+			got, gotCI := sll2ToSLL(tt.data, ci)
+			if !bytes.Equal(got, tt.want) {
+				t.Fatalf("got % x, want % x", got, tt.want)
+			}
+			if gotCI.CaptureLength != len(tt.want) {
+				t.Errorf("capture length %v, want %v", gotCI.CaptureLength, len(tt.want))
+			}
+			if gotCI.Length != len(tt.want)+100 {
+				t.Errorf("length %v, want %v", gotCI.Length, len(tt.want)+100)
+			}
+
+			if len(tt.data) < sll2HeaderLen {
+				return
+			}
+			p := gopacket.NewPacket(got, layers.LinkTypeLinuxSLL, gopacket.Default)
+			if p.Layer(layers.LayerTypeLinuxSLL) == nil {
+				t.Errorf("not decoded as linux sll: %v", p)
+			}
+
+			c := Context{
+				ci:       gotCI,
+				data:     got,
+				linkType: uint16(layers.LinkTypeLinuxSLL),
+			}
+			c.restoreCaptured()
+			if !bytes.Equal(c.data, tt.data) || c.linkType != linkTypeLinuxSLL2 {
+				t.Errorf("restored % x with link type %v, want the captured frame with link type %v", c.data, c.linkType, linkTypeLinuxSLL2)
+			}
+			if c.ci.CaptureLength != ci.CaptureLength || c.ci.Length != ci.Length {
+				t.Errorf("restored lengths %v %v, want %v %v", c.ci.CaptureLength, c.ci.Length, ci.CaptureLength, ci.Length)
+			}
+		})
+	}
+}
+
+func TestNgWriterLinkType(t *testing.T) {
+	for _, linkType := range []uint16{uint16(layers.LinkTypeEthernet), linkTypeLinuxSLL2} {
+		var b bytes.Buffer
+		options := pcapgo.DefaultNgWriterOptions
+		options.SectionInfo.Comment = "link type"
+		w, err := newNgWriter(&b, pcapgo.NgInterface{
+			Name:       "any",
+			SnapLength: 65536,
+		}, linkType, options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Flush(); err != nil {
+			t.Fatal(err)
+		}
+
+		data := b.Bytes()
+		at := int(binary.LittleEndian.Uint32(data[4:8]))
+		if got := binary.LittleEndian.Uint16(data[at+8:]); got != linkType {
+			t.Errorf("interface block link type %v, want %v", got, linkType)
+		}
+
+		r, err := pcapgo.NewNgReader(bytes.NewReader(data), pcapgo.DefaultNgReaderOptions)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := r.LinkType(); got != layers.LinkType(linkType) {
+			t.Errorf("read link type %v, want %v", got, layers.LinkType(linkType))
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"io"
+)
+
+// called for every tcp segment found by parsePackets
+// tcp, its payload and the packet data are only valid until the function returns
+type tcpHandler func(netFlow gopacket.Flow, tcp *layers.TCP, ci gopacket.CaptureInfo, data []byte)
+
+// decode packets with a DecodingLayerParser, which reuses the same layers for every packet
+// if the source supports it, packet data is read without copying it
+func parsePackets(ctx context.Context, src gopacket.PacketDataSource, linkType layers.LinkType, handle tcpHandler) error {
+	var (
+		eth     layers.Ethernet
+		lb      layers.Loopback
+		sll     layers.LinuxSLL
+		ip4     layers.IPv4
+		ip6     layers.IPv6
+		tcp     layers.TCP
+		payload gopacket.Payload
+	)
+
+	first, err := firstLayerType(linkType)
+	if err != nil {
+		return err
+	}
+
+	newParser := func(first gopacket.LayerType) *gopacket.DecodingLayerParser {
+		p := gopacket.NewDecodingLayerParser(first, &eth, &lb, &sll, &ip4, &ip6, &tcp, &payload)
+		// anything that isn't tcp over ip is ignored
+		p.IgnoreUnsupported = true
+		return p
+	}
+
+	parser := newParser(first)
+
+	// raw captures may carry either ip version, which is told apart by the first nibble
+	var parser6 *gopacket.DecodingLayerParser
+	if linkType == layers.LinkTypeRaw {
+		parser6 = newParser(layers.LayerTypeIPv6)
+	}
+
+	decoded := make([]gopacket.LayerType, 0, 8)
+
+	zc, zeroCopy := src.(gopacket.ZeroCopyPacketDataSource)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		default:
+		}
+
+		var (
+			data []byte
+			ci   gopacket.CaptureInfo
+		)
+		if zeroCopy {
+			data, ci, err = zc.ZeroCopyReadPacketData()
+		} else {
+			data, ci, err = src.ReadPacketData()
+		}
+
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			if isTemporary(err) {
+				continue
+			}
+			return fmt.Errorf("error reading packet: %v", err)
+		}
+
+		p := parser
+		if parser6 != nil && len(data) > 0 && data[0]>>4 == 6 {
+			p = parser6
+		}
+
+		if err := p.DecodeLayers(data, &decoded); err != nil {
+			continue
+		}
+
+		var (
+			netFlow        gopacket.Flow
+			foundNetLayer  bool
+			foundTransport bool
+		)
+		for _, typ := range decoded {
+			switch typ {
+			case layers.LayerTypeIPv4:
+				netFlow = ip4.NetworkFlow()
+				foundNetLayer = true
+			case layers.LayerTypeIPv6:
+				netFlow = ip6.NetworkFlow()
+				foundNetLayer = true
+			case layers.LayerTypeTCP:
+				foundTransport = true
+			}
+		}
+
+		if foundNetLayer && foundTransport {
+			handle(netFlow, &tcp, ci, data)
+		}
+	}
+}
+
+// layer the parser starts decoding from for each link type
+func firstLayerType(linkType layers.LinkType) (gopacket.LayerType, error) {
+	switch linkType {
+	case layers.LinkTypeEthernet:
+		return layers.LayerTypeEthernet, nil
+	case layers.LinkTypeNull, layers.LinkTypeLoop:
+		return layers.LayerTypeLoopback, nil
+	case layers.LinkTypeLinuxSLL:
+		return layers.LayerTypeLinuxSLL, nil
+	case layers.LinkTypeRaw, layers.LinkTypeIPv4:
+		return layers.LayerTypeIPv4, nil
+	case layers.LinkTypeIPv6:
+		return layers.LayerTypeIPv6, nil
+	default:
+		return gopacket.LayerTypeZero, fmt.Errorf("link type %v is not supported by the parser decoder", linkType)
+	}
+}
+
+// errors after which reading can be retried, e.g: read timeouts
+func isTemporary(err error) bool {
+	if t, ok := err.(interface{ Temporary() bool }); ok && t.Temporary() {
+		return true
+	}
+	if t, ok := err.(interface{ Timeout() bool }); ok && t.Timeout() {
+		return true
+	}
+	return false
+}
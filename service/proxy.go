@@ -0,0 +1,327 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"runtime"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// proxyRoute a local address clients connect to, and the server their traffic is forwarded to
+type proxyRoute struct {
+	Listen   string `mapstructure:"listen"`
+	Upstream string `mapstructure:"upstream"`
+}
+
+// Proxy forwards client connections to the servers, decoding their traffic without capturing packets
+func Proxy(cmd *cobra.Command, args []string) {
+	runtime.GOMAXPROCS(runtime.NumCPU())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	config(ctx)
+
+	sf, _ := newAssembler(ctx)
+
+	routes, err := proxyRoutes()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	hp, err := newHandoffProxy(ctx, sf)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	rules, err := loadPacketRules()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if hp != nil {
+		hp.rules = rules
+	}
+
+	var listeners []net.Listener
+	for _, r := range routes {
+		l, err := net.Listen("tcp", r.Listen)
+		if err != nil {
+			log.Fatal(err)
+		}
+		listeners = append(listeners, l)
+		log.Infof("proxying %v => %v", r.Listen, r.Upstream)
+		go acceptClients(ctx, l, r.Upstream, sf, hp, rules)
+	}
+
+	go startUI(ctx)
+
+	c := make(chan os.Signal, 2)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM) // subscribe to system signals
+	<-c
+
+	for _, l := range listeners {
+		if err := l.Close(); err != nil {
+			log.Error(err)
+		}
+	}
+	cancel()
+	exportEntitiesMovements()
+	exportSessions()
+}
+
+// proxy.routes if set, otherwise every port in network.specificPorts is forwarded to proxy.upstreamHost
+func proxyRoutes() ([]proxyRoute, error) {
+	var routes []proxyRoute
+	if err := viper.UnmarshalKey("proxy.routes", &routes); err != nil {
+		return nil, err
+	}
+	if len(routes) > 0 {
+		return routes, nil
+	}
+
+	host := viper.GetString("proxy.upstreamHost")
+	if host == "" {
+		return nil, fmt.Errorf("required config parameter is missing: proxy.routes or proxy.upstreamHost")
+	}
+
+	for _, p := range viper.GetIntSlice("network.specificPorts.ports") {
+		port := strconv.Itoa(p)
+		routes = append(routes, proxyRoute{
+			Listen:   net.JoinHostPort(viper.GetString("proxy.listenHost"), port),
+			Upstream: net.JoinHostPort(host, port),
+		})
+	}
+
+	if len(routes) == 0 {
+		return nil, fmt.Errorf("no ports to proxy, network.specificPorts.ports is empty")
+	}
+	return routes, nil
+}
+
+// hp is nil if handoff packets should not be rewritten
+func acceptClients(ctx context.Context, l net.Listener, upstream string, sf *shineStreamFactory, hp *handoffProxy, rules packetRules) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Error(err)
+			continue
+		}
+		go proxyConnection(ctx, conn, upstream, sf, hp, rules)
+	}
+}
+
+// forward a client connection to the server, both byte streams are fed to the decoders as they are forwarded
+func proxyConnection(ctx context.Context, client net.Conn, upstream string, sf *shineStreamFactory, hp *handoffProxy, rules packetRules) {
+	server, err := net.DialTimeout("tcp", upstream, 10*time.Second)
+	if err != nil {
+		log.Errorf("could not connect to upstream %v for client %v: %v", upstream, client.RemoteAddr(), err)
+		if err := client.Close(); err != nil {
+			log.Error(err)
+		}
+		return
+	}
+
+	netFlow, transport, err := connectionFlows(client.RemoteAddr(), server.RemoteAddr())
+	if err != nil {
+		log.Error(err)
+	}
+
+	s := sf.newStream(netFlow, transport, Context{}, streamRole{
+		detectedBy: "proxy",
+	})
+
+	// client packets are only framed if rules may change them, server packets also if handoffs are rewritten
+	var clientFramer, serverFramer *proxyFramer
+	if len(rules) > 0 || hp != nil {
+		ps := &proxySession{
+			hp:    hp,
+			rules: rules,
+		}
+		if len(rules) > 0 {
+			clientFramer = &proxyFramer{
+				ps:         ps,
+				fromClient: true,
+			}
+		}
+		serverFramer = &proxyFramer{
+			ps: ps,
+		}
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		proxyCopy(server, client, s, true, clientFramer)
+	}()
+	go func() {
+		defer wg.Done()
+		proxyCopy(client, server, s, false, serverFramer)
+	}()
+
+	done := waitChan(&wg)
+	select {
+	case <-ctx.Done():
+	case <-done:
+	}
+
+	// unblocks the copies if the proxy is shutting down
+	client.Close()
+	server.Close()
+	<-done
+
+	s.close()
+	log.Warningf("proxied connection closed [ %v - %v]", netFlow, transport)
+}
+
+// forward data from src to dst until src is done, then half close dst so it sees the same FIN
+// data is decoded as dst receives it, after rules change it, f is nil if data is forwarded as it is
+func proxyCopy(dst, src net.Conn, s *shineStream, fromClient bool, f *proxyFramer) {
+	out := make(chan proxyWrite, 64)
+	written := make(chan struct{})
+	go proxyWriter(dst, src, out, written)
+
+	defer func() {
+		close(out)
+		<-written
+		if tc, ok := dst.(*net.TCPConn); ok {
+			_ = tc.CloseWrite()
+		}
+	}()
+
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			now := time.Now()
+			if f == nil {
+				data := make([]byte, n)
+				copy(data, buf[:n])
+				s.push(shineSegment{
+					data: data,
+					seen: now,
+				}, fromClient)
+				out <- proxyWrite{
+					data: data,
+				}
+			} else {
+				packets, logged := f.write(buf[:n])
+				if len(logged) > 0 {
+					s.push(shineSegment{
+						data: logged,
+						seen: now,
+					}, fromClient)
+				}
+				for _, p := range packets {
+					out <- proxyWrite{
+						data: p.data,
+						at:   now.Add(p.delay),
+					}
+				}
+			}
+		}
+
+		if err != nil {
+			if err != io.EOF {
+				log.Warningf("error reading from %v: %v", src.RemoteAddr(), err)
+			}
+			if f != nil {
+				data := f.flush()
+				if len(data) > 0 {
+					s.push(shineSegment{
+						data: data,
+						seen: time.Now(),
+					}, fromClient)
+				}
+				out <- proxyWrite{
+					data: data,
+				}
+			}
+			return
+		}
+	}
+}
+
+// proxyWrite data to forward and when, zero to send it right away
+type proxyWrite struct {
+	data []byte
+	at   time.Time
+}
+
+// write to dst in order, a delayed write holds back the ones queued after it
+// src is closed if dst can't be written anymore, so the copy stops reading
+func proxyWriter(dst, src net.Conn, out <-chan proxyWrite, written chan<- struct{}) {
+	defer close(written)
+
+	var last time.Time
+	failed := false
+	for w := range out {
+		if failed || len(w.data) == 0 {
+			continue
+		}
+		if w.at.Before(last) {
+			w.at = last
+		}
+		last = w.at
+		if d := time.Until(w.at); d > 0 {
+			time.Sleep(d)
+		}
+		if _, err := dst.Write(w.data); err != nil {
+			log.Warningf("error forwarding to %v: %v", dst.RemoteAddr(), err)
+			failed = true
+			src.Close()
+		}
+	}
+}
+
+// network and transport flows from the client to the server, same as a captured connection would have
+func connectionFlows(client, server net.Addr) (gopacket.Flow, gopacket.Flow, error) {
+	c, ok := client.(*net.TCPAddr)
+	if !ok {
+		return gopacket.Flow{}, gopacket.Flow{}, fmt.Errorf("unexpected client address %v", client)
+	}
+	s, ok := server.(*net.TCPAddr)
+	if !ok {
+		return gopacket.Flow{}, gopacket.Flow{}, fmt.Errorf("unexpected server address %v", server)
+	}
+
+	clientIP, serverIP := c.IP, s.IP
+	// keep both endpoints the same ip version
+	if c4, s4 := clientIP.To4(), serverIP.To4(); c4 != nil && s4 != nil {
+		clientIP, serverIP = c4, s4
+	} else {
+		clientIP, serverIP = clientIP.To16(), serverIP.To16()
+	}
+
+	netFlow, err := gopacket.FlowFromEndpoints(layers.NewIPEndpoint(clientIP), layers.NewIPEndpoint(serverIP))
+	if err != nil {
+		return gopacket.Flow{}, gopacket.Flow{}, err
+	}
+	transport, err := gopacket.FlowFromEndpoints(layers.NewTCPPortEndpoint(layers.TCPPort(c.Port)), layers.NewTCPPortEndpoint(layers.TCPPort(s.Port)))
+	if err != nil {
+		return gopacket.Flow{}, gopacket.Flow{}, err
+	}
+	return netFlow, transport, nil
+}
+
+// closed once the wait group is done
+func waitChan(wg *sync.WaitGroup) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	return done
+}
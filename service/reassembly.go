@@ -6,7 +6,6 @@ import (
 	"fmt"
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
-	"github.com/google/gopacket/pcap"
 	"github.com/google/gopacket/reassembly"
 	"github.com/google/logger"
 	"github.com/google/uuid"
@@ -16,9 +15,14 @@ import (
 	"path/filepath"
 	"strconv"
 	"sync"
+	"time"
 )
 
 func init() {
+	// tests run in the package directory, where output doesn't exist yet
+	if err := os.MkdirAll("output", 0700); err != nil {
+		logger.Fatalf("Failed to create output directory: %v", err)
+	}
 	lf, err := os.OpenFile("output/streams.log", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0660)
 	if err != nil {
 		logger.Fatalf("Failed to open log file: %v", err)
@@ -28,31 +32,74 @@ func init() {
 }
 
 type shineStreamFactory struct {
-	shineContext   context.Context
-	localAddresses []pcap.InterfaceAddress
+	shineContext context.Context
+	// tracks the decoding goroutines of every stream, so offline decoding can wait for them to finish
+	wg sync.WaitGroup
+	// why the assembler is completing streams, set while a sweep or a flush runs
+	closeReason string
 }
 
 type shineStream struct {
 	flowID         string
+	iface          string
+	agent          string
 	net, transport gopacket.Flow
-	client         chan<- shineSegment
-	server         chan<- shineSegment
-	packets        chan<- decodedPacket
-	xorKey         chan<- uint16
+	client         chan shineSegment
+	server         chan shineSegment
+	packets        chan decodedPacket
 	cancel         context.CancelFunc
 	isServer       bool
+	role           streamRole
+	closed         bool
 	mu             sync.Mutex
+	// segments of each direction are queued by one goroutine at a time, the channels aren't closed while they're held
+	sendMu [2]sync.Mutex
+	// packets of the connection, opened with the first packet that has data to write
+	pcap       *flowFile
+	pcapFailed bool
+	pcapMu     sync.Mutex
+	// sequence number of the last frame written to pcap, segments reassembled after it were completed by it
+	lastFrame uint64
+	// last packets of the connection, if capture.ring is enabled
+	ring *packetRing
+	// whether a segment was reassembled for each direction of the assembler
+	started [2]bool
+	// data each decoder holds until it has complete packets
+	clientBuffer, serverBuffer *streamBuffer
+	// player session the stream was linked to, guarded by the session tracker
+	session *session
+	factory *shineStreamFactory
+	// segments queued for each decoder, client first, and the bytes lost with the ones backpressure dropped, by index
+	queued  [2]uint64
+	dropped [2]map[uint64]int
+	dropMu  sync.Mutex
+	drops   *dropCounters
 }
 
 var (
-	iface             string
+	ifaces            []string
 	snaplen           int
 	filter            string
+	ports             serverPorts
 	log               *logger.Logger
 	serverSideCapture bool
+	structsWatcher    sync.Once
 )
 
-func config() {
+// serverPorts ports the services listen on, as configured for the bpf filter
+type serverPorts struct {
+	start, end int
+	specific   map[int]bool
+}
+
+func (sp serverPorts) has(port int) bool {
+	if sp.specific != nil {
+		return sp.specific[port]
+	}
+	return port >= sp.start && port <= sp.end
+}
+
+func config(ctx context.Context) {
 	dir, err := filepath.Abs("output/")
 	if _, err := os.Stat(dir); os.IsNotExist(err) {
 
@@ -69,19 +116,32 @@ func config() {
 		log.Error(err)
 	}
 
-	iface = viper.GetString("network.interface")
+	ifaces = captureInterfaces()
 	serverSideCapture = viper.GetBool("network.serverSideCapture")
+	persistFlows = viper.GetBool("capture.persistFlows")
+	loadServerIPs()
 	snaplen = viper.GetInt("network.snaplen")
+	if snaplen <= 0 {
+		log.Fatalf("network.snaplen must be positive, got %v", snaplen)
+	}
 
 	if viper.GetBool("network.portRange.useThis") {
 		startPort := viper.GetString("network.portRange.start")
 		endPort := viper.GetString("network.portRange.end")
 		portRange := fmt.Sprintf("%v-%v", startPort, endPort)
 		filter = fmt.Sprintf("tcp and portrange %v", portRange)
+		ports = serverPorts{
+			start: viper.GetInt("network.portRange.start"),
+			end:   viper.GetInt("network.portRange.end"),
+		}
 		log.Infof("using bpf filter %v", filter)
 	} else {
 		specificPorts := viper.GetIntSlice("network.specificPorts.ports")
+		ports = serverPorts{
+			specific: make(map[int]bool),
+		}
 		for i, p := range specificPorts {
+			ports.specific[p] = true
 			if i == 0 {
 				filter = fmt.Sprintf("tcp port %v", p)
 			} else {
@@ -100,45 +160,173 @@ func config() {
 
 	s.XorKey = xorKey
 
-	xorLimit, err := strconv.Atoi(viper.GetString("protocol.xorLimit"))
+	limit, err := strconv.Atoi(viper.GetString("protocol.xorLimit"))
 
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	s.XorLimit = uint16(xorLimit)
+	xorLimit = uint16(limit)
+	s.XorLimit = xorLimit
 	if path, err := filepath.Abs(viper.GetString("protocol.commands")); err != nil {
 		log.Error(err)
 	} else {
 		s.CommandsFilePath = path
 	}
 	s.Set()
+
+	if commandNames, err = loadCommandNames(s.CommandsFilePath); err != nil {
+		log.Errorf("could not load command names: %v", err)
+	}
+
+	if path, err := filepath.Abs(viper.GetString("protocol.structs")); err != nil {
+		log.Error(err)
+	} else {
+		if err := ncStructs.load(path); err != nil {
+			log.Errorf("could not load struct definitions: %v", err)
+		}
+		// a single watcher, even if config is called again
+		structsWatcher.Do(func() {
+			go ncStructs.watch(ctx, path, viper.GetDuration("protocol.structsReload"))
+		})
+	}
+
+	loadXorRecoveryConfig()
+
+	if err := loadPacketLengthConfig(); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := loadBufferConfig(); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := loadBackpressureConfig(); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := loadSessionConfig(); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := loadRingConfig(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// network.interfaces takes precedence over network.interface
+func captureInterfaces() []string {
+	if viper.IsSet("network.interfaces") {
+		return viper.GetStringSlice("network.interfaces")
+	}
+	return []string{viper.GetString("network.interface")}
 }
 
 func (ss *shineStream) Accept(tcp *layers.TCP, ci gopacket.CaptureInfo, dir reassembly.TCPFlowDirection, nextSeq reassembly.Sequence, start *bool, ac reassembly.AssemblerContext) bool {
-	// todo: save it to pcap file
+	c, ok := ac.(Context)
+	if !ok || c.data == nil {
+		return true
+	}
+	// the ring replaces flow files, only what's around a trigger is written
+	if ss.ring != nil {
+		ss.ring.add(c)
+	} else if persistFlows {
+		ss.savePacket(c)
+	}
 	return true
 }
 
+// write the raw packet to the flow file of the stream
+func (ss *shineStream) savePacket(c Context) {
+	if ss.pcapFailed {
+		return
+	}
+	if ss.pcap == nil {
+		ff, err := newFlowFile(ss, c.linkType)
+		if err != nil {
+			log.Errorf("could not create flow file for stream [ %v - %v]: %v", ss.net, ss.transport, err)
+			ss.pcapFailed = true
+			return
+		}
+		// the decoders read it to annotate frames
+		ss.pcapMu.Lock()
+		ss.pcap = ff
+		ss.pcapMu.Unlock()
+	}
+	seq, err := ss.pcap.write(c.ci, c.data)
+	if err != nil {
+		log.Errorf("error writing flow file %v: %v", ss.pcap.path, err)
+	}
+	ss.lastFrame = seq
+}
+
+// comment the frame that completed a shine packet with its command
+func (ss *shineStream) annotateFrame(frame uint64, p *networking.Command, direction string) {
+	ss.pcapMu.Lock()
+	ff := ss.pcap
+	ss.pcapMu.Unlock()
+	if ff == nil || frame == 0 {
+		return
+	}
+	ff.annotate(frame, fmt.Sprintf("%v opcode=%v direction=%v flow=%v", networking.CommandName(p), p.Base.OperationCode, direction, ss.flowID))
+}
+
+// write what's left of the flow file, once the decoders are done with it
+func (ss *shineStream) closeFlowFile() {
+	ss.pcapMu.Lock()
+	ff := ss.pcap
+	ss.pcapMu.Unlock()
+	if ff != nil {
+		ff.close()
+	}
+}
+
 func (ssf *shineStreamFactory) New(net, transport gopacket.Flow, tcp *layers.TCP, ac reassembly.AssemblerContext) reassembly.Stream {
+	var c Context
+	if ctx, ok := ac.(Context); ok {
+		c = ctx
+	}
+
+	return ssf.newStream(net, transport, c, detectRole(net, transport, tcp))
+}
+
+// create a stream and start its decoders, streams are fed segments with push() and finished with close()
+func (ssf *shineStreamFactory) newStream(net, transport gopacket.Flow, c Context, role streamRole) *shineStream {
 	ctx, cancel := context.WithCancel(ssf.shineContext)
 
-	xorKey := make(chan uint16)
-	xorKeyFound := make(chan bool)
+	// the server side decoder sends the xor offset at most once, and closes the channel once it can't come anymore
+	xorKey := make(chan uint16, 1)
 
 	s := &shineStream{
 		flowID:    uuid.New().String(),
+		iface:     c.iface,
+		agent:     c.agent,
 		net:       net,
 		transport: transport,
-		xorKey:    xorKey,
 		cancel:    cancel,
-		isServer:  false,
+		isServer:  role.isServer,
+		role:      role,
+		factory:   ssf,
+		drops:     &dropCounters{},
+
+		clientBuffer: newStreamBuffer(),
+		serverBuffer: newStreamBuffer(),
 	}
 
-	srcPort, _ := strconv.Atoi(transport.Src().String())
-	if srcPort >= 9000 && srcPort <= 9600 {
-		// server - client
-		s.isServer = true
+	clientAddr, serverAddr := s.endpoints()
+	log.Infof("new stream %v, client %v server %v, detected by %v", s.flowID, clientAddr, serverAddr, role.detectedBy)
+	activeStreams.add(s)
+
+	if sessions != nil {
+		at := c.ci.Timestamp
+		if at.IsZero() {
+			at = time.Now()
+		}
+		sessions.attach(s, at)
+	}
+
+	if ringCapture.enabled {
+		s.ring = &packetRing{}
 	}
 
 	client := make(chan shineSegment, 512)
@@ -148,11 +336,47 @@ func (ssf *shineStreamFactory) New(net, transport gopacket.Flow, tcp *layers.TCP
 	s.server = server
 	s.packets = packets
 
-	go s.decodeServerPackets(ctx, server, xorKeyFound, xorKey)
-	go s.decodeClientPackets(ctx, client, xorKeyFound, xorKey)
-	go s.handleDecodedPackets(ctx, packets)
+	var decoders sync.WaitGroup
+	decoders.Add(2)
+	// the packet handler, and closing the flow file once the decoders are done
+	ssf.wg.Add(2)
 
-	log.Infof("new stream from => [ %v ] [ %v ]", net, transport)
+	go func() {
+		defer decoders.Done()
+		s.decodeServerPackets(ctx, server, xorKey)
+		drainSegments(ctx, server)
+	}()
+
+	go func() {
+		defer decoders.Done()
+		s.decodeClientPackets(ctx, client, xorKey)
+		drainSegments(ctx, client)
+	}()
+
+	go func() {
+		defer ssf.wg.Done()
+		// no more packets will be produced once both directions are done
+		decoders.Wait()
+		close(packets)
+		// every frame that completed a packet was annotated
+		s.closeFlowFile()
+		activeStreams.remove(s)
+		if dc := s.drops.snapshot(); dc.any() {
+			log.Warningf("stream %v closed, dropped by backpressure: %+v", s.flowID, dc)
+		}
+	}()
+
+	go func() {
+		defer ssf.wg.Done()
+		defer s.cancel()
+		s.handleDecodedPackets(ctx, packets)
+	}()
+
+	if s.agent != "" {
+		log.Infof("new stream from agent %v => [ %v ] [ %v ] [ %v ]", s.agent, s.iface, net, transport)
+	} else {
+		log.Infof("new stream from => [ %v ] [ %v ] [ %v ]", s.iface, net, transport)
+	}
 	return s
 }
 
@@ -162,26 +386,94 @@ func (ss *shineStream) ReassembledSG(sg reassembly.ScatterGather, ac reassembly.
 		return
 	}
 
-	dir, _, _, _ := sg.Info()
+	dir, start, _, skip := sg.Info()
+
+	// a stream picked up without its handshake starts wherever the capture did
+	gap := skip
+	d := 0
+	if dir == reassembly.TCPDirServerToClient {
+		d = 1
+	}
+	if !ss.started[d] {
+		ss.started[d] = true
+		if !start && gap == 0 {
+			gap = -1
+		}
+	}
+
+	// the fetched bytes may point into packet data or pages the assembler reuses
+	data := make([]byte, length)
+	copy(data, sg.Fetch(length))
 
 	seg := shineSegment{
-		data: sg.Fetch(length),
-		seen: ac.GetCaptureInfo().Timestamp,
+		data:  data,
+		frame: ss.lastFrame,
+		gap:   gap,
 	}
-	//log.Info(dir, ss.net.String())
-	ss.mu.Lock()
-	if dir == reassembly.TCPDirClientToServer && !ss.isServer {
-		seg.direction = "outbound"
-		ss.client <- seg
+
+	// the assembler doesn't provide a context when flushing
+	if ac != nil {
+		seg.seen = ac.GetCaptureInfo().Timestamp
 	} else {
-		seg.direction = "inbound"
-		ss.server <- seg
+		seg.seen = sg.CaptureInfo(0).Timestamp
+	}
+	// the assembler's client is whoever sent the first segment, which is the server if isServer
+	ss.push(seg, (dir == reassembly.TCPDirClientToServer) != ss.isServer)
+}
+
+// send a segment to the decoder of its direction
+func (ss *shineStream) push(seg shineSegment, fromClient bool) {
+	segments, d := ss.server, 1
+	seg.direction = "inbound"
+	if fromClient {
+		segments, d = ss.client, 0
+		seg.direction = "outbound"
+	}
+
+	ss.mu.Lock()
+	if ss.closed {
+		ss.mu.Unlock()
+		return
+	}
+	// a full channel only holds up the segments of this direction, the decoders don't need ss.mu
+	ss.sendMu[d].Lock()
+	ss.mu.Unlock()
+	ss.queueSegment(segments, seg, d)
+	ss.sendMu[d].Unlock()
+}
+
+// no more segments will be pushed
+// closing the segment channels lets the decoders drain whatever is still buffered before quitting
+func (ss *shineStream) close() {
+	ss.mu.Lock()
+	if !ss.closed {
+		ss.closed = true
+		// wait for the segments being queued
+		for d := range ss.sendMu {
+			ss.sendMu[d].Lock()
+		}
+		close(ss.client)
+		close(ss.server)
+		for d := range ss.sendMu {
+			ss.sendMu[d].Unlock()
+		}
 	}
 	ss.mu.Unlock()
 }
 
 func (ss *shineStream) ReassemblyComplete(ac reassembly.AssemblerContext) bool {
-	log.Warningf("reassembly complete for stream [ %v - %v]", ss.net.String(), ss.transport.String()) // ip of the stream, port of the stream
-	ss.cancel()
+	// the assembler only calls it while holding the lock of the shared assembler, so the reason can't change under it
+	reason := ss.factory.closeReason
+	if reason == "" {
+		reason = "closed"
+	}
+	log.Warningf("reassembly complete for stream [ %v - %v] (%v)", ss.net.String(), ss.transport.String(), reason) // ip of the stream, port of the stream
+	// closing the segment channels ends the decoders, which in turn end the handler and release the buffers
+	ss.close()
+	go uiCompletedFlow(completedFlow{
+		FlowCompleted: true,
+		FlowID:        ss.flowID,
+		Reason:        reason,
+	})
 	return false
 }
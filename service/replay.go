@@ -0,0 +1,263 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/gopacket"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"net/http"
+	"os"
+	"os/signal"
+	"runtime"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// replayClock paces packets of a capture file according to the time they were originally captured
+type replayClock struct {
+	speed  float64
+	paused bool
+	// timestamp of the first packet in the file
+	first time.Time
+	// packets captured before jumpTo are assembled without delay
+	jumpTo     time.Time
+	jumpOffset time.Duration
+	// capture timestamp and wall clock time from which delays are calculated
+	origin  time.Time
+	started time.Time
+	// capture timestamp of the last released packet
+	last time.Time
+	// closed and replaced every time the replay settings change
+	changed chan struct{}
+	mu      sync.Mutex
+	// wall clock, and a timer that fires after a delay with a function that stops it
+	now   func() time.Time
+	after func(d time.Duration) (<-chan time.Time, func() bool)
+}
+
+var rc *replayClock
+
+func newReplayClock(speed float64) *replayClock {
+	return &replayClock{
+		speed:   speed,
+		changed: make(chan struct{}),
+		now:     time.Now,
+		after: func(d time.Duration) (<-chan time.Time, func() bool) {
+			t := time.NewTimer(d)
+			return t.C, t.Stop
+		},
+	}
+}
+
+// Replay packets stored in a pcap or pcapng file at the pace they were captured
+func Replay(cmd *cobra.Command, args []string) {
+	runtime.GOMAXPROCS(runtime.NumCPU())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	config(ctx)
+
+	rc = newReplayClock(viper.GetFloat64("replay.speed"))
+
+	if rc.speed <= 0 {
+		log.Fatalf("invalid replay speed %v", rc.speed)
+	}
+
+	if from := viper.GetString("replay.from"); from != "" {
+		if err := rc.jump(from); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	sf, a := newAssembler(ctx)
+
+	handle := openCaptureFile(args[0])
+	defer handle.Close()
+
+	http.HandleFunc("/replay/pause", replayPause)
+	http.HandleFunc("/replay/resume", replayResume)
+	http.HandleFunc("/replay/speed", replaySpeed)
+	http.HandleFunc("/replay/jump", replayJump)
+
+	go startUI(ctx)
+
+	c := make(chan os.Signal, 2)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM) // subscribe to system signals
+	go func() {
+		select {
+		case <-c:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	ps := gopacket.NewPacketSource(handle, handle.LinkType())
+	for packet := range ps.Packets() {
+		if err := rc.wait(ctx, packet.Metadata().Timestamp); err != nil {
+			log.Warningf("replay canceled")
+			break
+		}
+		assemblePacket(a, packet, Context{
+			linkType: uint16(handle.LinkType()),
+		})
+	}
+
+	log.Infof("replay of %v finished", args[0])
+
+	a.FlushAll()
+	sf.wg.Wait()
+
+	exportEntitiesMovements()
+	exportSessions()
+}
+
+// block until a packet captured at ts should be released
+func (r *replayClock) wait(ctx context.Context, ts time.Time) error {
+	for {
+		r.mu.Lock()
+		if r.first.IsZero() {
+			r.first = ts
+			if r.jumpOffset != 0 {
+				r.jumpTo = r.first.Add(r.jumpOffset)
+			}
+		}
+
+		if ts.Before(r.jumpTo) {
+			// fast forward, the pace starts again from the first packet after the jump
+			r.origin = time.Time{}
+			r.last = ts
+			r.mu.Unlock()
+			return nil
+		}
+
+		if r.origin.IsZero() {
+			r.origin = ts
+			r.started = r.now()
+		}
+
+		paused := r.paused
+		delay := r.started.Add(time.Duration(float64(ts.Sub(r.origin)) / r.speed)).Sub(r.now())
+		changed := r.changed
+		r.mu.Unlock()
+
+		if !paused && delay <= 0 {
+			r.mu.Lock()
+			r.last = ts
+			r.mu.Unlock()
+			return nil
+		}
+
+		var (
+			timeout <-chan time.Time
+			stop    func() bool
+		)
+		if !paused {
+			timeout, stop = r.after(delay)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		case <-timeout:
+		}
+
+		if stop != nil {
+			stop()
+		}
+	}
+}
+
+// restart pacing from the last released packet, must be called with the lock held
+func (r *replayClock) rebase() {
+	r.origin = r.last
+	r.started = r.now()
+	close(r.changed)
+	r.changed = make(chan struct{})
+}
+
+func (r *replayClock) pause() {
+	r.mu.Lock()
+	r.paused = true
+	r.rebase()
+	r.mu.Unlock()
+}
+
+func (r *replayClock) resume() {
+	r.mu.Lock()
+	r.paused = false
+	r.rebase()
+	r.mu.Unlock()
+}
+
+func (r *replayClock) setSpeed(speed float64) error {
+	if speed <= 0 {
+		return fmt.Errorf("invalid replay speed %v", speed)
+	}
+	r.mu.Lock()
+	r.speed = speed
+	r.rebase()
+	r.mu.Unlock()
+	return nil
+}
+
+// skip ahead to a timestamp (RFC3339) or to an offset from the first packet (e.g: 90s)
+// packets are assembled in order, so it's not possible to jump backwards
+func (r *replayClock) jump(to string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var target time.Time
+	if offset, err := time.ParseDuration(to); err == nil {
+		if r.first.IsZero() {
+			r.jumpOffset = offset
+			return nil
+		}
+		target = r.first.Add(offset)
+	} else if t, err := time.Parse(time.RFC3339Nano, to); err == nil {
+		target = t
+	} else {
+		return fmt.Errorf("invalid jump target %v, expected a duration or a RFC3339 timestamp", to)
+	}
+
+	if !r.last.IsZero() && target.Before(r.last) {
+		return fmt.Errorf("can't jump back to %v, replay is already at %v", target, r.last)
+	}
+
+	r.jumpTo = target
+	r.rebase()
+	return nil
+}
+
+func replayPause(w http.ResponseWriter, r *http.Request) {
+	rc.pause()
+	log.Info("replay paused")
+}
+
+func replayResume(w http.ResponseWriter, r *http.Request) {
+	rc.resume()
+	log.Info("replay resumed")
+}
+
+func replaySpeed(w http.ResponseWriter, r *http.Request) {
+	speed, err := strconv.ParseFloat(r.URL.Query().Get("x"), 64)
+	if err == nil {
+		err = rc.setSpeed(speed)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	log.Infof("replay speed set to %vx", speed)
+}
+
+func replayJump(w http.ResponseWriter, r *http.Request) {
+	to := r.URL.Query().Get("to")
+	if err := rc.jump(to); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	log.Infof("replay jumping to %v", to)
+}
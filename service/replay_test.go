@@ -0,0 +1,189 @@
+package service
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeReplayTime records the delays the replay clock waits for, timers fire at once and move the clock forward
+type fakeReplayTime struct {
+	now    time.Time
+	delays []time.Duration
+	mu     sync.Mutex
+}
+
+func newTestReplayClock() (*replayClock, *fakeReplayTime) {
+	ft := &fakeReplayTime{
+		now: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	r := newReplayClock(1)
+	r.now = func() time.Time {
+		ft.mu.Lock()
+		defer ft.mu.Unlock()
+		return ft.now
+	}
+	r.after = func(d time.Duration) (<-chan time.Time, func() bool) {
+		ft.mu.Lock()
+		defer ft.mu.Unlock()
+		ft.delays = append(ft.delays, d)
+		ft.now = ft.now.Add(d)
+		c := make(chan time.Time, 1)
+		c <- ft.now
+		return c, func() bool {
+			return false
+		}
+	}
+	return r, ft
+}
+
+func (ft *fakeReplayTime) requested() []time.Duration {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	return append([]time.Duration{}, ft.delays...)
+}
+
+func TestReplayClockWait(t *testing.T) {
+	base := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		speed float64
+		jump  string
+		// capture offsets of the packets from the first one
+		packets []time.Duration
+		// delays the clock waits for before releasing them
+		want []time.Duration
+	}{
+		{"captured pace", 1, "", []time.Duration{0, 40 * time.Millisecond, 100 * time.Millisecond}, []time.Duration{40 * time.Millisecond, 60 * time.Millisecond}},
+		{"same timestamp", 1, "", []time.Duration{0, 0, 10 * time.Millisecond}, []time.Duration{10 * time.Millisecond}},
+		{"double speed", 2, "", []time.Duration{0, 80 * time.Millisecond, 160 * time.Millisecond}, []time.Duration{40 * time.Millisecond, 40 * time.Millisecond}},
+		{"half speed", 0.5, "", []time.Duration{0, 40 * time.Millisecond}, []time.Duration{80 * time.Millisecond}},
+		{"jump before the first packet", 1, "10s", []time.Duration{0, 5 * time.Second, 10 * time.Second, 10*time.Second + 60*time.Millisecond}, []time.Duration{60 * time.Millisecond}},
+		{"jump to a timestamp", 1, base.Add(time.Hour).Format(time.RFC3339Nano), []time.Duration{0, 30 * time.Minute, time.Hour, time.Hour + 60*time.Millisecond}, []time.Duration{60 * time.Millisecond}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, ft := newTestReplayClock()
+			if err := r.setSpeed(tt.speed); err != nil {
+				t.Fatal(err)
+			}
+			if tt.jump != "" {
+				if err := r.jump(tt.jump); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			for _, p := range tt.packets {
+				if err := r.wait(context.Background(), base.Add(p)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := ft.requested(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("waited for %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// a slow consumer doesn't wait for packets that are already late
+func TestReplayClockLate(t *testing.T) {
+	base := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	r, ft := newTestReplayClock()
+	if err := r.wait(context.Background(), base); err != nil {
+		t.Fatal(err)
+	}
+
+	ft.mu.Lock()
+	ft.now = ft.now.Add(time.Second)
+	ft.mu.Unlock()
+	for _, p := range []time.Duration{500 * time.Millisecond, time.Second, 1500 * time.Millisecond} {
+		if err := r.wait(context.Background(), base.Add(p)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []time.Duration{500 * time.Millisecond}
+	if got := ft.requested(); !reflect.DeepEqual(got, want) {
+		t.Errorf("waited for %v, want %v", got, want)
+	}
+}
+
+func TestReplayClockPause(t *testing.T) {
+	base := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	r, ft := newTestReplayClock()
+	if err := r.wait(context.Background(), base); err != nil {
+		t.Fatal(err)
+	}
+	r.pause()
+
+	released := make(chan error, 1)
+	go func() {
+		released <- r.wait(context.Background(), base.Add(time.Second))
+	}()
+
+	select {
+	case err := <-released:
+		t.Fatalf("packet released while paused: %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	if got := ft.requested(); len(got) != 0 {
+		t.Errorf("waited for %v while paused", got)
+	}
+
+	// the pace starts again from the last released packet
+	r.resume()
+	if err := <-released; err != nil {
+		t.Fatal(err)
+	}
+	want := []time.Duration{time.Second}
+	if got := ft.requested(); !reflect.DeepEqual(got, want) {
+		t.Errorf("waited for %v, want %v", got, want)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r.pause()
+	cancel()
+	if err := r.wait(ctx, base.Add(2*time.Second)); err != context.Canceled {
+		t.Errorf("got %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestReplayClockSettings(t *testing.T) {
+	base := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		speed   float64
+		jump    string
+		wantErr bool
+	}{
+		{"speed", 4, "", false},
+		{"zero speed", 0, "", true},
+		{"negative speed", -1, "", true},
+		{"jump ahead", 1, "1m", false},
+		{"jump back", 1, base.Format(time.RFC3339Nano), true},
+		{"invalid jump", 1, "later", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, _ := newTestReplayClock()
+			// the replay is one second into the file
+			r.first = base
+			r.last = base.Add(time.Second)
+
+			var err error
+			if tt.jump != "" {
+				err = r.jump(tt.jump)
+			} else {
+				err = r.setSpeed(tt.speed)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package service
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"github.com/spf13/viper"
+)
+
+// longest packets each side is expected to send, protocol.maxPacketLength, anything longer means the framing was lost
+var (
+	maxClientPacketLength int
+	maxServerPacketLength int
+)
+
+func loadPacketLengthConfig() error {
+	maxClientPacketLength = viper.GetInt("protocol.maxPacketLength.client")
+	maxServerPacketLength = viper.GetInt("protocol.maxPacketLength.server")
+	for side, l := range map[string]int{
+		"client": maxClientPacketLength,
+		"server": maxServerPacketLength,
+	} {
+		// the length is at most two bytes, and every packet has an operation code
+		if l < 2 || l > 65535 {
+			return fmt.Errorf("protocol.maxPacketLength.%v %v is not between 2 and 65535", side, l)
+		}
+	}
+	return nil
+}
+
+// streamGap bytes of a stream that couldn't be decoded, offsets are relative to the start of the stream as it was captured
+type streamGap struct {
+	Gap           bool   `json:"gap"`
+	FlowID        string `json:"flow_id"`
+	ConnectionKey string `json:"connectionKey"`
+	Direction     string `json:"direction"`
+	Start         int64  `json:"start"`
+	End           int64  `json:"end"`
+	Reason        string `json:"reason"`
+}
+
+func (sg *streamGap) String() string {
+	sd, err := json.Marshal(&sg)
+	if err != nil {
+		log.Error(err)
+	}
+	return string(sd)
+}
+
+func uiStreamGap(sg streamGap) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	for c, active := range ws.cons {
+		if active {
+			err := c.WriteMessage(websocket.TextMessage, []byte(sg.String()))
+			if err != nil {
+				log.Error("write:", err)
+				break
+			}
+		}
+	}
+}
+
+// log and send to the ui the range of bytes skipped to find the framing again
+func (ss *shineStream) reportGap(direction string, start, end int64, reason string) {
+	if end == start {
+		log.Infof("[%v %v] %v decoding resumed without skipping bytes: %v", ss.net, ss.transport, direction, reason)
+		return
+	}
+	log.Warningf("[%v %v] %v decoding resumed, skipped bytes %v-%v: %v", ss.net, ss.transport, direction, start, end, reason)
+	uiStreamGap(streamGap{
+		Gap:           true,
+		FlowID:        ss.flowID,
+		ConnectionKey: connectionKey(ss.net, ss.transport),
+		Direction:     direction,
+		Start:         start,
+		End:           end,
+		Reason:        reason,
+	})
+}
+
+// the xor offset moves with every deciphered byte, so once bytes are lost or misframed there is no telling where it is,
+// it's recovered from the packets after the gap instead
+func (ss *shineStream) dropXorKey() {
+	if !xorRecoverySet.enabled {
+		log.Errorf("[%v %v] xor offset lost and protocol.xorRecovery is disabled, the rest of the stream can't be deciphered", ss.net, ss.transport)
+		return
+	}
+	log.Warningf("[%v %v] xor offset lost, recovering it from the next packets", ss.net, ss.transport)
+}
+
+// first position in data where packets can be framed again, for streams that aren't ciphered
+// a position is accepted if its length is plausible and its operation code is listed in protocol.commands,
+// and so are the next packet's, so a random match isn't enough
+// if found is false more data is needed, and scanning can restart from pos
+func resyncPacket(data []byte, maxLen int) (pos int, found bool) {
+	for i := 0; i < len(data); i++ {
+		next, ok, more := packetAt(data, i, maxLen)
+		if more {
+			return i, false
+		}
+		if !ok {
+			continue
+		}
+
+		_, ok, more = packetAt(data, next, maxLen)
+		if more {
+			return i, false
+		}
+		if ok {
+			return i, true
+		}
+	}
+	return len(data), false
+}
+
+// check the packet that would start at position i, ok if it looks like a shine packet
+// more is set if it can't be told yet
+func packetAt(data []byte, i, maxLen int) (next int, ok, more bool) {
+	start, end, ok := packetBounds(data, i)
+	if !ok {
+		return 0, false, true
+	}
+	if pLen := end - start; pLen < 2 || pLen > maxLen {
+		return 0, false, false
+	}
+	if start+2 > len(data) {
+		return 0, false, true
+	}
+
+	opCode := binary.LittleEndian.Uint16(data[start:])
+	// without a commands file only the length can be checked
+	if _, known := commandNames[opCode]; !known && len(commandNames) > 0 {
+		return 0, false, false
+	}
+	return end, true, false
+}
+
+// first position in data where the xor offset can be recovered from, for client streams whose offset is unknown
+// if found is false more data is needed, and scanning can restart from pos, every position before it was ruled out
+func resyncUnkeyed(data []byte, maxLen int) (pos int, xorOffset uint16, found bool) {
+	pos = len(data)
+	for i := 0; i < len(data); i++ {
+		start, end, ok := packetBounds(data, i)
+		if !ok {
+			if i < pos {
+				pos = i
+			}
+			break
+		}
+		if pLen := end - start; pLen < 2 || pLen > maxLen {
+			continue
+		}
+		x, ok, more := recoverXorOffset(data[i:])
+		if ok {
+			return i, x, true
+		}
+		if more && i < pos {
+			pos = i
+		}
+	}
+	return pos, 0, false
+}
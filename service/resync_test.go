@@ -0,0 +1,258 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/shine-o/shine.engine.core/networking"
+	"github.com/spf13/viper"
+)
+
+type testPacket struct {
+	opCode uint16
+	data   []byte
+}
+
+var testPackets = []testPacket{
+	{2055, []byte{0x01, 0x02}},
+	{6145, bytes.Repeat([]byte{0xee}, 10)},
+	{6147, nil},
+	{8217, bytes.Repeat([]byte{0xee}, 300)},
+	{3073, bytes.Repeat([]byte{0xee}, 7)},
+	{6145, bytes.Repeat([]byte{0xee}, 4)},
+	{2055, []byte{0x03, 0x04}},
+}
+
+// a deterministic xor table, and the operation codes of testPackets as protocol.commands
+func setupXorStreams(t *testing.T) {
+	t.Helper()
+	key := make([]byte, 350)
+	rand.New(rand.NewSource(1)).Read(key)
+	s := networking.Settings{
+		XorKey:           key,
+		XorLimit:         uint16(len(key)),
+		CommandsFilePath: "../config/commands.yml",
+	}
+	s.Set()
+
+	xorLimit = uint16(len(key))
+	xorRecoverySet = xorRecovery{
+		enabled:    true,
+		minPackets: 3,
+	}
+	commandNames = make(map[uint16]string)
+	for _, p := range testPackets {
+		commandNames[p.opCode] = "NC_TEST"
+	}
+}
+
+// frame the packets as a client sends them, the body of each packet is ciphered if ciphered is set
+// returns the position of every packet and the xor offset of its body
+func testStream(packets []testPacket, ciphered bool, xorOffset uint16) ([]byte, []int, []uint16) {
+	var (
+		stream  []byte
+		starts  []int
+		offsets []uint16
+	)
+	for _, p := range packets {
+		body := make([]byte, 2+len(p.data))
+		binary.LittleEndian.PutUint16(body, p.opCode)
+		copy(body[2:], p.data)
+
+		starts = append(starts, len(stream))
+		offsets = append(offsets, xorOffset)
+		if len(body) > 255 {
+			stream = append(stream, 0, byte(len(body)), byte(len(body)>>8))
+		} else {
+			stream = append(stream, byte(len(body)))
+		}
+		if ciphered {
+			networking.XorCipher(body, &xorOffset)
+		}
+		stream = append(stream, body...)
+	}
+	return stream, starts, offsets
+}
+
+func TestRecoverXorOffset(t *testing.T) {
+	setupXorStreams(t)
+	stream, starts, offsets := testStream(testPackets, true, 17)
+	wrapped, _, wrappedOffsets := testStream(testPackets, true, 345)
+	unknown, _, _ := testStream([]testPacket{{1, nil}, {2, nil}, {3, nil}, {4, nil}}, true, 17)
+
+	tests := []struct {
+		name       string
+		data       []byte
+		disabled   bool
+		want       uint16
+		wantFound  bool
+		wantMore   bool
+		minPackets int
+	}{
+		{"first packet", stream, false, offsets[0], true, false, 3},
+		{"later packet", stream[starts[2]:], false, offsets[2], true, false, 3},
+		{"offset wraps around the limit", wrapped, false, wrappedOffsets[0], true, false, 3},
+		{"not enough packets", stream[:starts[2]], false, 0, false, true, 3},
+		{"incomplete packets aren't counted", stream[:starts[4]-1], false, 0, false, true, 4},
+		{"recovery disabled", stream, true, 0, false, false, 3},
+		{"unknown operation codes", unknown, false, 0, false, false, 3},
+		{"not a packet boundary", stream[starts[1]+1:], false, 0, false, false, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			xorRecoverySet.enabled = !tt.disabled
+			xorRecoverySet.minPackets = tt.minPackets
+			defer setupXorStreams(t)
+
+			got, found, more := recoverXorOffset(tt.data)
+			if found != tt.wantFound || got != tt.want || more != tt.wantMore {
+				t.Errorf("got %v %v %v, want %v %v %v", got, found, more, tt.want, tt.wantFound, tt.wantMore)
+			}
+		})
+	}
+}
+
+// a gap in a ciphered stream, the framing and the xor offset are found again from the packets after it
+func TestResyncUnkeyed(t *testing.T) {
+	setupXorStreams(t)
+	stream, starts, offsets := testStream(testPackets, true, 120)
+	// lengths over the limit, ruled out whatever follows them
+	garbage := bytes.Repeat([]byte{0xff}, 20)
+
+	tests := []struct {
+		name      string
+		data      []byte
+		maxLen    int
+		disabled  bool
+		wantPos   int
+		wantXor   uint16
+		wantFound bool
+	}{
+		{"packet boundary", stream[starts[1]:], 4096, false, 0, offsets[1], true},
+		{"middle of a packet", stream[starts[1]+5:], 4096, false, starts[2] - (starts[1] + 5), offsets[2], true},
+		{"middle of a big packet", stream[starts[3]+100:], 4096, false, starts[4] - (starts[3] + 100), offsets[4], true},
+		{"not enough packets after the gap", stream[starts[5]+1:], 4096, false, 0, 0, false},
+		{"garbage is dropped while waiting for packets", append(append([]byte{}, garbage...), stream[:starts[2]]...), 200, false, len(garbage), 0, false},
+		{"waiting for a big length", append(append([]byte{}, garbage...), 0x00, 0x01), 200, false, len(garbage), 0, false},
+		{"recovery disabled", stream, 4096, true, len(stream), 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			xorRecoverySet.enabled = !tt.disabled
+			defer setupXorStreams(t)
+
+			pos, x, found := resyncUnkeyed(tt.data, tt.maxLen)
+			if pos != tt.wantPos || x != tt.wantXor || found != tt.wantFound {
+				t.Errorf("got %v %v %v, want %v %v %v", pos, x, found, tt.wantPos, tt.wantXor, tt.wantFound)
+			}
+		})
+	}
+}
+
+func TestResyncPacket(t *testing.T) {
+	setupXorStreams(t)
+	stream, starts, _ := testStream(testPackets, false, 0)
+	// lengths that are too short or too long, and lengths followed by unknown operation codes
+	garbage := []byte{0x01, 0xff, 0xff, 0x00, 0xff, 0xff, 0x04, 0x01, 0x00, 0x05, 0x06}
+
+	tests := []struct {
+		name      string
+		data      []byte
+		maxLen    int
+		wantPos   int
+		wantFound bool
+	}{
+		{"packet boundary", stream, 4096, 0, true},
+		{"garbage before a packet", append(append([]byte{}, garbage...), stream...), 4096, len(garbage), true},
+		{"middle of a packet", stream[starts[1]+2:], 4096, starts[2] - (starts[1] + 2), true},
+		{"longer than the limit", stream[starts[3]:], 255, starts[4] - starts[3], true},
+		{"needs the next packet", stream[:starts[1]], 4096, 0, false},
+		{"needs the length of a big packet", stream[starts[3] : starts[3]+2], 4096, 0, false},
+		{"only garbage", []byte{0x01, 0xff, 0xff, 0xff, 0x01, 0x01}, 4096, 6, false},
+		{"needs an operation code", garbage[:8], 4096, 6, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pos, found := resyncPacket(tt.data, tt.maxLen)
+			if pos != tt.wantPos || found != tt.wantFound {
+				t.Errorf("got %v %v, want %v %v", pos, found, tt.wantPos, tt.wantFound)
+			}
+		})
+	}
+}
+
+// the client side decoder doesn't recover the offset while the server's seed may still come
+func TestClientWaitsForSeed(t *testing.T) {
+	setupXorStreams(t)
+	packets := testPackets[1:]
+	stream, _, _ := testStream(packets, true, 17)
+
+	defer func(limits bufferSettings, maxLen int, logClient bool) {
+		bufferLimits = limits
+		maxClientPacketLength = maxLen
+		viper.Set("protocol.log.client", logClient)
+	}(bufferLimits, maxClientPacketLength, viper.GetBool("protocol.log.client"))
+	bufferLimits = bufferSettings{
+		streamMax: minStreamBuffer,
+		globalMax: 1 << 30,
+		policy:    "drop",
+	}
+	maxClientPacketLength = 4096
+	viper.Set("protocol.log.client", true)
+
+	tests := []struct {
+		name string
+		// the server sends the seed, or closes the channel without one
+		seed bool
+	}{
+		{"seed", true},
+		{"no seed", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ss := &shineStream{
+				drops:        &dropCounters{},
+				clientBuffer: newStreamBuffer(),
+				packets:      make(chan decodedPacket, len(packets)),
+			}
+			segments := make(chan shineSegment, 1)
+			xorKey := make(chan uint16, 1)
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				ss.decodeClientPackets(context.Background(), segments, xorKey)
+			}()
+
+			segments <- shineSegment{data: stream}
+			time.Sleep(20 * time.Millisecond)
+			if n := len(ss.packets); n != 0 {
+				t.Fatalf("%v packets decoded before the server side was done with the seed", n)
+			}
+
+			if tt.seed {
+				xorKey <- 17
+			}
+			close(xorKey)
+			close(segments)
+			<-done
+
+			if n := len(ss.packets); n != len(packets) {
+				t.Fatalf("%v packets decoded, want %v", n, len(packets))
+			}
+			for _, p := range packets {
+				dp := <-ss.packets
+				if dp.packet.Base.OperationCode != p.opCode {
+					t.Errorf("got operation code %v, want %v", dp.packet.Base.OperationCode, p.opCode)
+				}
+			}
+		})
+	}
+}
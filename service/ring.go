@@ -0,0 +1,220 @@
+package service
+
+import (
+	"fmt"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/shine-o/shine.engine.core/networking"
+	"github.com/shine-o/shine.engine.core/structs"
+	"github.com/spf13/viper"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ringSettings capture.ring, keeps the last packets of every flow in memory and only writes them when a trigger fires
+type ringSettings struct {
+	enabled  bool
+	window   time.Duration
+	maxBytes int
+	// triggers
+	opCodes       map[uint16]bool
+	unknownOpCode bool
+	decodeFailure bool
+	badLength     bool
+}
+
+// packetRing last packets of a flow, the oldest are dropped once the window or size limit is exceeded
+type packetRing struct {
+	frames   []ringFrame
+	bytes    int
+	linkType uint16
+	lastDump time.Time
+	mu       sync.Mutex
+}
+
+type ringFrame struct {
+	ci   gopacket.CaptureInfo
+	data []byte
+}
+
+var ringCapture ringSettings
+
+func loadRingConfig() error {
+	ringCapture = ringSettings{
+		enabled:       viper.GetBool("capture.ring.enabled"),
+		window:        viper.GetDuration("capture.ring.window"),
+		maxBytes:      viper.GetInt("capture.ring.megabytes") << 20,
+		opCodes:       make(map[uint16]bool),
+		unknownOpCode: viper.GetBool("capture.ring.triggers.unknownOpCode"),
+		decodeFailure: viper.GetBool("capture.ring.triggers.decodeFailure"),
+		badLength:     viper.GetBool("capture.ring.triggers.badLength"),
+	}
+
+	for _, op := range viper.GetIntSlice("capture.ring.triggers.opCodes") {
+		ringCapture.opCodes[uint16(op)] = true
+	}
+
+	for _, name := range viper.GetStringSlice("capture.ring.triggers.commands") {
+		found := false
+		for op, n := range commandNames {
+			if n == name {
+				ringCapture.opCodes[op] = true
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("capture.ring.triggers.commands: unknown command %v", name)
+		}
+	}
+
+	if ringCapture.enabled {
+		log.Infof("keeping the last %v or %v MB of every flow, dumped when a trigger fires", ringCapture.window, ringCapture.maxBytes>>20)
+	}
+	return nil
+}
+
+func (r *packetRing) add(c Context) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	data := make([]byte, len(c.data))
+	copy(data, c.data)
+
+	r.linkType = c.linkType
+	r.frames = append(r.frames, ringFrame{
+		ci:   c.ci,
+		data: data,
+	})
+	r.bytes += len(data)
+
+	newest := c.ci.Timestamp
+	n := 0
+	for n < len(r.frames)-1 && (r.bytes > ringCapture.maxBytes || newest.Sub(r.frames[n].ci.Timestamp) > ringCapture.window) {
+		r.bytes -= len(r.frames[n].data)
+		n++
+	}
+	if n > 0 {
+		// drop the references, so the evicted packets can be collected
+		r.frames = append(r.frames[:0:0], r.frames[n:]...)
+	}
+}
+
+// write the packets of the stream's ring to output/triggers, at most once per window
+func (ss *shineStream) triggerRing(reason string) {
+	if ss.ring == nil {
+		return
+	}
+
+	r := ss.ring
+	r.mu.Lock()
+	if !r.lastDump.IsZero() && time.Since(r.lastDump) < ringCapture.window {
+		r.mu.Unlock()
+		log.Warningf("trigger %v for stream [ %v - %v] ignored, it was dumped %v ago", reason, ss.net, ss.transport, time.Since(r.lastDump))
+		return
+	}
+	if len(r.frames) == 0 {
+		r.mu.Unlock()
+		return
+	}
+	r.lastDump = time.Now()
+	frames := make([]ringFrame, len(r.frames))
+	copy(frames, r.frames)
+	linkType := r.linkType
+	r.mu.Unlock()
+
+	path, err := ss.dumpRing(frames, linkType, reason)
+	if err != nil {
+		log.Errorf("could not dump stream [ %v - %v]: %v", ss.net, ss.transport, err)
+		return
+	}
+	log.Warningf("trigger %v fired for stream [ %v - %v], %v packets written to %v", reason, ss.net, ss.transport, len(frames), path)
+}
+
+func (ss *shineStream) dumpRing(frames []ringFrame, linkType uint16, reason string) (string, error) {
+	dir, err := filepath.Abs("output/triggers")
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+
+	key := connectionKey(ss.net, ss.transport)
+	name := fmt.Sprintf("%v_%v_%v.pcapng", time.Now().Format("20060102T150405"), flowFileNames.Replace(key), ss.flowID)
+	path := filepath.Join(dir, name)
+
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	options := pcapgo.DefaultNgWriterOptions
+	options.SectionInfo.Comment = fmt.Sprintf("trigger: %v\n%v", reason, flowFileComment(ss, key))
+
+	w, err := newNgWriter(f, pcapgo.NgInterface{
+		Name:       ss.iface,
+		SnapLength: uint32(snaplen),
+	}, linkType, options)
+	if err != nil {
+		return "", err
+	}
+
+	for _, fr := range frames {
+		ci := fr.ci
+		ci.InterfaceIndex = 0
+		if err := w.WritePacket(ci, fr.data); err != nil {
+			return "", err
+		}
+	}
+	return path, w.Flush()
+}
+
+// fire the opcode and decode failure triggers for a decoded packet
+// the struct is unpacked here, as packets are only unpacked for the log if protocol.log is enabled
+func (ss *shineStream) checkTriggers(p *networking.Command) {
+	if ss.ring == nil {
+		return
+	}
+	op := p.Base.OperationCode
+	_, known := commandNames[op]
+	switch {
+	case ringCapture.opCodes[op]:
+		ss.triggerRing(fmt.Sprintf("opcode %v", op))
+	case ringCapture.unknownOpCode && commandNames != nil && !known:
+		ss.triggerRing(fmt.Sprintf("unknown opcode %v", op))
+	case ringCapture.decodeFailure:
+		if nc := ncStruct(op); nc != nil {
+			if err := structs.Unpack(p.Base.Data, nc); err != nil {
+				ss.triggerRing(fmt.Sprintf("struct decode failure for opcode %v: %v", op, err))
+			}
+		}
+	}
+}
+
+// manual trigger, /capture/trigger?flow=<flow id>&reason=<text>, every flow is dumped if flow is empty
+func captureTriggerHandler(w http.ResponseWriter, r *http.Request) {
+	if !ringCapture.enabled {
+		http.Error(w, "capture.ring is not enabled", http.StatusBadRequest)
+		return
+	}
+
+	flowID := r.URL.Query().Get("flow")
+	reason := r.URL.Query().Get("reason")
+	if reason == "" {
+		reason = "manual"
+	}
+
+	streams := activeStreams.find(flowID)
+	if flowID != "" && len(streams) == 0 {
+		http.Error(w, fmt.Sprintf("flow %v not found", flowID), http.StatusNotFound)
+		return
+	}
+	for _, ss := range streams {
+		ss.triggerRing(reason)
+	}
+	log.Infof("manual trigger fired for %v flows", len(streams))
+}
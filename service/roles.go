@@ -0,0 +1,147 @@
+package service
+
+import (
+	"encoding/json"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/spf13/viper"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// streamRole which side of a stream is the server, and how it was told
+type streamRole struct {
+	// the source of the stream's flows is the server
+	isServer bool
+	// syn, syn-ack, port, ip, proxy or guess
+	detectedBy string
+}
+
+// streamRegistry streams that are being decoded
+type streamRegistry struct {
+	streams map[string]*shineStream
+	mu      sync.Mutex
+}
+
+// streamInfo how a stream is exposed by /capture/streams
+type streamInfo struct {
+	FlowID     string `json:"flow_id"`
+	Client     string `json:"client"`
+	Server     string `json:"server"`
+	DetectedBy string `json:"detected_by"`
+	Interface  string `json:"interface,omitempty"`
+	Agent      string `json:"agent,omitempty"`
+	Session    string `json:"session,omitempty"`
+}
+
+var (
+	// network.serverIPs
+	serverIPs     map[string]bool
+	activeStreams = &streamRegistry{
+		streams: make(map[string]*shineStream),
+	}
+)
+
+func loadServerIPs() {
+	serverIPs = make(map[string]bool)
+	for _, s := range viper.GetStringSlice("network.serverIPs") {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			log.Errorf("network.serverIPs: invalid ip %v", s)
+			continue
+		}
+		serverIPs[ip.String()] = true
+	}
+}
+
+// tell which side is the server from the first segment of a stream
+// the handshake is the most reliable, then the configured server ports, then the configured server ips
+// a stream picked up in the middle with none of them matching is guessed by the lowest port
+func detectRole(netFlow, transport gopacket.Flow, tcp *layers.TCP) streamRole {
+	if tcp != nil && tcp.SYN {
+		if tcp.ACK {
+			return streamRole{isServer: true, detectedBy: "syn-ack"}
+		}
+		return streamRole{isServer: false, detectedBy: "syn"}
+	}
+
+	srcPort, _ := strconv.Atoi(transport.Src().String())
+	dstPort, _ := strconv.Atoi(transport.Dst().String())
+	if src, dst := ports.has(srcPort), ports.has(dstPort); src != dst {
+		return streamRole{isServer: src, detectedBy: "port"}
+	}
+
+	if src, dst := serverIPs[ipString(netFlow.Src())], serverIPs[ipString(netFlow.Dst())]; src != dst {
+		return streamRole{isServer: src, detectedBy: "ip"}
+	}
+
+	return streamRole{isServer: srcPort < dstPort, detectedBy: "guess"}
+}
+
+// same format as net.IP.String(), so endpoints can be looked up in serverIPs
+func ipString(e gopacket.Endpoint) string {
+	return net.IP(e.Raw()).String()
+}
+
+// client and server endpoints, as detected
+func (ss *shineStream) endpoints() (string, string) {
+	src := net.JoinHostPort(ss.net.Src().String(), ss.transport.Src().String())
+	dst := net.JoinHostPort(ss.net.Dst().String(), ss.transport.Dst().String())
+	if ss.isServer {
+		return dst, src
+	}
+	return src, dst
+}
+
+func (sr *streamRegistry) add(ss *shineStream) {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	sr.streams[ss.flowID] = ss
+}
+
+func (sr *streamRegistry) remove(ss *shineStream) {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	delete(sr.streams, ss.flowID)
+}
+
+// streams with the flow id, or every stream if it's empty
+func (sr *streamRegistry) find(flowID string) []*shineStream {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	var streams []*shineStream
+	for id, ss := range sr.streams {
+		if flowID == "" || flowID == id {
+			streams = append(streams, ss)
+		}
+	}
+	return streams
+}
+
+// roles of the streams being decoded, to find flows whose client and server were mixed up
+func captureStreamsHandler(w http.ResponseWriter, r *http.Request) {
+	var infos []streamInfo
+	for _, ss := range activeStreams.find("") {
+		client, server := ss.endpoints()
+		infos = append(infos, streamInfo{
+			FlowID:     ss.flowID,
+			Client:     client,
+			Server:     server,
+			DetectedBy: ss.role.detectedBy,
+			Interface:  ss.iface,
+			Agent:      ss.agent,
+			Session:    ss.sessionID(),
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Server+infos[i].Client < infos[j].Server+infos[j].Client
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(infos); err != nil {
+		log.Error(err)
+	}
+}
@@ -0,0 +1,412 @@
+package service
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/shine-o/shine.engine.core/networking"
+	"github.com/shine-o/shine.engine.core/structs"
+	"github.com/spf13/viper"
+	"gopkg.in/restruct.v1"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// packetRule intercepts packets in proxy mode
+// a rule matches by command name or operation code, direction and values of the decoded struct
+type packetRule struct {
+	Name      string                 `mapstructure:"name"`
+	OpCode    uint16                 `mapstructure:"opCode"`
+	Direction string                 `mapstructure:"direction"`
+	Where     map[string]interface{} `mapstructure:"where"`
+	// drop, delay, duplicate or set
+	Action string        `mapstructure:"action"`
+	Delay  time.Duration `mapstructure:"delay"`
+	// extra copies sent by duplicate, default is 1
+	Times int `mapstructure:"times"`
+	// struct fields set by set, nested fields are separated by dots, e.g: To.X
+	Set map[string]interface{} `mapstructure:"set"`
+}
+
+// interceptedPacket packet data, without length header, and how long to hold it before it's sent
+type interceptedPacket struct {
+	data  []byte
+	delay time.Duration
+}
+
+type packetRules []packetRule
+
+func loadPacketRules() (packetRules, error) {
+	var rules packetRules
+	if err := viper.UnmarshalKey("proxy.rules", &rules); err != nil {
+		return nil, err
+	}
+	for i, r := range rules {
+		switch r.Action {
+		case "drop", "delay", "duplicate", "set":
+		default:
+			return nil, fmt.Errorf("rule %v: unknown action %v", i, r.Action)
+		}
+		if r.Name == "" && r.OpCode == 0 {
+			return nil, fmt.Errorf("rule %v: name or opCode is required", i)
+		}
+		if r.Action == "duplicate" && r.Times == 0 {
+			rules[i].Times = 1
+		}
+	}
+	return rules, nil
+}
+
+// apply every matching rule to a decrypted packet, in order
+// returns the packets that should be sent instead, none if it's dropped
+func (rules packetRules) apply(data []byte, direction string) []interceptedPacket {
+	out := []interceptedPacket{
+		{
+			data: data,
+		},
+	}
+
+	if len(rules) == 0 || len(data) < 2 {
+		return out
+	}
+
+	p, err := networking.DecodePacket(data)
+	if err != nil {
+		return out
+	}
+	name := networking.CommandName(&p)
+
+	// decoded lazily, only rules that look into the struct need it
+	var fields map[string]interface{}
+
+	for i, r := range rules {
+		if r.Direction != "" && r.Direction != direction {
+			continue
+		}
+		if r.OpCode != 0 && r.OpCode != p.Base.OperationCode {
+			continue
+		}
+		if r.Name != "" && r.Name != name {
+			continue
+		}
+
+		if len(r.Where) > 0 || len(r.Set) > 0 {
+			if fields == nil {
+				fields, err = ncStructFields(p.Base.OperationCode, p.Base.Data)
+				if err != nil {
+					log.Errorf("rule %v: %v", i, err)
+					continue
+				}
+			}
+			if !fieldsMatch(fields, r.Where) {
+				continue
+			}
+		}
+
+		switch r.Action {
+		case "drop":
+			log.Infof("rule %v: dropped %v %v\noriginal: %v", i, direction, name, hex.EncodeToString(data))
+			return nil
+		case "delay":
+			for j := range out {
+				out[j].delay += r.Delay
+			}
+			log.Infof("rule %v: delayed %v %v by %v", i, direction, name, r.Delay)
+		case "duplicate":
+			for j := 0; j < r.Times; j++ {
+				out = append(out, out[0])
+			}
+			log.Infof("rule %v: duplicated %v %v %v times", i, direction, name, r.Times)
+		case "set":
+			for path, v := range r.Set {
+				if err := setField(fields, path, v); err != nil {
+					log.Errorf("rule %v: %v", i, err)
+				}
+			}
+			modified, err := packNcStruct(p.Base.OperationCode, fields)
+			if err != nil {
+				log.Errorf("rule %v: %v", i, err)
+				continue
+			}
+			log.Infof("rule %v: modified %v %v\noriginal: %v\nmodified: %v", i, direction, name, hex.EncodeToString(out[0].data), hex.EncodeToString(modified))
+			for j := range out {
+				out[j].data = modified
+			}
+		}
+	}
+	return out
+}
+
+// decode the struct of a packet as generic json values, numbers are json.Number so 64 bit fields keep every digit
+func ncStructFields(opCode uint16, data []byte) (map[string]interface{}, error) {
+	nc := ncStruct(opCode)
+	if nc == nil {
+		return nil, fmt.Errorf("no struct assigned to this operation code %v", opCode)
+	}
+	if err := structs.Unpack(data, nc); err != nil {
+		return nil, err
+	}
+	b, err := json.Marshal(nc)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]interface{}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	if err := d.Decode(&fields); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+// serialize struct fields back into packet data, operation code included
+func packNcStruct(opCode uint16, fields map[string]interface{}) ([]byte, error) {
+	nc := ncStruct(opCode)
+	if nc == nil {
+		return nil, fmt.Errorf("no struct assigned to this operation code %v", opCode)
+	}
+	b, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, nc); err != nil {
+		return nil, err
+	}
+	ncData, err := restruct.Pack(binary.LittleEndian, nc)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, 2, 2+len(ncData))
+	binary.LittleEndian.PutUint16(data, opCode)
+	return append(data, ncData...), nil
+}
+
+func fieldsMatch(fields map[string]interface{}, where map[string]interface{}) bool {
+	for path, want := range where {
+		got, ok := getField(fields, path)
+		if !ok || !fieldEqual(got, want) {
+			return false
+		}
+	}
+	return true
+}
+
+// numbers are compared by value, as written in the config they may be ints, floats or strings
+func fieldEqual(got, want interface{}) bool {
+	n, ok := got.(json.Number)
+	if !ok {
+		return fmt.Sprint(got) == fmt.Sprint(want)
+	}
+	w, ok := numberString(want)
+	if !ok {
+		return false
+	}
+	if n.String() == w {
+		return true
+	}
+	// both are written without exponent or leading zeros, different integers are different numbers
+	if isInteger(n.String()) && isInteger(w) {
+		return false
+	}
+	gf, err := n.Float64()
+	if err != nil {
+		return false
+	}
+	wf, err := strconv.ParseFloat(w, 64)
+	return err == nil && gf == wf
+}
+
+func numberString(v interface{}) (string, bool) {
+	switch n := v.(type) {
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return fmt.Sprint(n), true
+	case float32:
+		return strconv.FormatFloat(float64(n), 'f', -1, 32), true
+	case float64:
+		return strconv.FormatFloat(n, 'f', -1, 64), true
+	case json.Number:
+		return n.String(), true
+	case string:
+		return n, true
+	}
+	return "", false
+}
+
+func isInteger(s string) bool {
+	if _, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return true
+	}
+	_, err := strconv.ParseUint(s, 10, 64)
+	return err == nil
+}
+
+// fields are matched case insensitively, as config keys are lower cased
+func getField(fields map[string]interface{}, path string) (interface{}, bool) {
+	var v interface{} = fields
+	for _, k := range strings.Split(path, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if v, ok = m[fieldKey(m, k)]; !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+func setField(fields map[string]interface{}, path string, value interface{}) error {
+	keys := strings.Split(path, ".")
+	m := fields
+	for _, k := range keys[:len(keys)-1] {
+		next, ok := m[fieldKey(m, k)].(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("field %v not found", path)
+		}
+		m = next
+	}
+	last := fieldKey(m, keys[len(keys)-1])
+	if _, ok := m[last]; !ok {
+		return fmt.Errorf("field %v not found", path)
+	}
+	m[last] = value
+	return nil
+}
+
+func fieldKey(m map[string]interface{}, k string) string {
+	if _, ok := m[k]; ok {
+		return k
+	}
+	for mk := range m {
+		if strings.EqualFold(mk, k) {
+			return mk
+		}
+	}
+	return k
+}
+
+// proxySession state shared by both directions of a proxied connection
+type proxySession struct {
+	hp    *handoffProxy
+	rules packetRules
+	// xor offset the server sent in NC_MISC_SEED_ACK, client packets can't be changed until it's known
+	seed    uint16
+	hasSeed bool
+	mu      sync.Mutex
+}
+
+func (ps *proxySession) setSeed(seed uint16) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	ps.seed = seed
+	ps.hasSeed = true
+}
+
+func (ps *proxySession) xorSeed() (uint16, bool) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	return ps.seed, ps.hasSeed
+}
+
+// proxyFramer splits one direction of a proxied connection into packets, so rules can be applied to them
+// client packets are deciphered before rules see them and ciphered again before they're sent,
+// with an offset of their own, as dropped or duplicated packets move the offset the server expects
+type proxyFramer struct {
+	ps         *proxySession
+	fromClient bool
+	// bytes of a packet that didn't arrive completely yet
+	pending []byte
+	// xor offset of the packets received and of the packets sent
+	ciphered            bool
+	inOffset, outOffset uint16
+}
+
+// returns the complete packets in data, with their length header, after rules and handoff rewrites are applied
+// and the same packets as they are decoded, before handoffs are rewritten, sessions are linked by the servers they point to
+func (f *proxyFramer) write(data []byte) ([]interceptedPacket, []byte) {
+	f.pending = append(f.pending, data...)
+
+	var (
+		out    []interceptedPacket
+		logged []byte
+	)
+	offset := 0
+	for offset < len(f.pending) {
+		start, end, ok := packetBounds(f.pending, offset)
+		if !ok || end > len(f.pending) {
+			break
+		}
+
+		packetData := make([]byte, end-start)
+		copy(packetData, f.pending[start:end])
+		offset = end
+
+		for _, p := range f.packet(packetData) {
+			logged = append(logged, framePacket(p.data)...)
+			if !f.fromClient && f.ps.hp != nil {
+				rewritten := make([]byte, len(p.data))
+				copy(rewritten, p.data)
+				f.ps.hp.rewrite(rewritten)
+				p.data = rewritten
+			}
+			p.data = framePacket(p.data)
+			out = append(out, p)
+		}
+	}
+
+	f.pending = f.pending[offset:]
+	return out, logged
+}
+
+func (f *proxyFramer) packet(data []byte) []interceptedPacket {
+	if !f.fromClient {
+		if len(data) >= 4 && binary.LittleEndian.Uint16(data) == 2055 {
+			f.ps.setSeed(binary.LittleEndian.Uint16(data[2:]))
+		}
+		return f.ps.rules.apply(data, "inbound")
+	}
+
+	if !f.ciphered {
+		seed, ok := f.ps.xorSeed()
+		if !ok {
+			return []interceptedPacket{
+				{
+					data: data,
+				},
+			}
+		}
+		f.ciphered = true
+		f.inOffset, f.outOffset = seed, seed
+	}
+
+	networking.XorCipher(data, &f.inOffset)
+	out := f.ps.rules.apply(data, "outbound")
+	for i := range out {
+		ciphered := make([]byte, len(out[i].data))
+		copy(ciphered, out[i].data)
+		networking.XorCipher(ciphered, &f.outOffset)
+		out[i].data = ciphered
+	}
+	return out
+}
+
+// whatever is left when the connection is closed
+func (f *proxyFramer) flush() []byte {
+	out := f.pending
+	f.pending = nil
+	return out
+}
+
+// prepend the length header, packets shorter than 255 bytes use a single byte
+func framePacket(data []byte) []byte {
+	if len(data) < 255 {
+		return append([]byte{byte(len(data))}, data...)
+	}
+	framed := make([]byte, 3, 3+len(data))
+	binary.LittleEndian.PutUint16(framed[1:], uint16(len(data)))
+	return append(framed, data...)
+}
@@ -0,0 +1,185 @@
+package service
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/shine-o/shine.engine.core/networking"
+	"github.com/shine-o/shine.engine.core/structs"
+	"gopkg.in/restruct.v1"
+)
+
+type ruleTestStruct struct {
+	Handle uint16
+	Amount uint64
+	Signed int64
+	To     structs.ShineXYType
+}
+
+const ruleTestOpCode = 0xfff0
+
+func setupRuleStruct(t *testing.T) {
+	t.Helper()
+	// packets are decoded with the names of the commands file
+	s := networking.Settings{
+		CommandsFilePath: "../config/commands.yml",
+	}
+	s.Set()
+
+	ncStructs.mu.Lock()
+	ncStructs.types[ruleTestOpCode] = reflect.TypeOf(ruleTestStruct{})
+	ncStructs.mu.Unlock()
+}
+
+func TestFieldsMatch(t *testing.T) {
+	fields := map[string]interface{}{
+		"Handle": json.Number("4096"),
+		"Amount": json.Number("18446744073709551615"),
+		"Signed": json.Number("-9007199254740993"),
+		"Rate":   json.Number("1.5"),
+		"Name":   "bob",
+		"To": map[string]interface{}{
+			"X": json.Number("1000000"),
+			"Y": json.Number("20"),
+		},
+	}
+
+	tests := []struct {
+		name  string
+		where map[string]interface{}
+		want  bool
+	}{
+		{"no conditions", nil, true},
+		{"int", map[string]interface{}{"handle": 4096}, true},
+		{"different int", map[string]interface{}{"handle": 4097}, false},
+		{"largest uint64", map[string]interface{}{"amount": uint64(18446744073709551615)}, true},
+		{"uint64 that is the same float", map[string]interface{}{"amount": uint64(18446744073709551614)}, false},
+		{"int64 beyond float precision", map[string]interface{}{"signed": int64(-9007199254740993)}, true},
+		{"int64 that is the same float", map[string]interface{}{"signed": int64(-9007199254740992)}, false},
+		{"million as int", map[string]interface{}{"to.x": 1000000}, true},
+		{"million as float", map[string]interface{}{"to.x": 1e6}, true},
+		{"million as string", map[string]interface{}{"to.x": "1000000"}, true},
+		{"float", map[string]interface{}{"rate": 1.5}, true},
+		{"string", map[string]interface{}{"name": "bob"}, true},
+		{"every condition", map[string]interface{}{"handle": 4096, "to.y": 20}, true},
+		{"one condition fails", map[string]interface{}{"handle": 4096, "to.y": 21}, false},
+		{"missing field", map[string]interface{}{"missing": 1}, false},
+		{"not a struct", map[string]interface{}{"handle.x": 1}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := fieldsMatch(fields, tt.where); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPackNcStruct(t *testing.T) {
+	setupRuleStruct(t)
+
+	tests := []struct {
+		name string
+		nc   ruleTestStruct
+		set  map[string]interface{}
+		want ruleTestStruct
+	}{
+		{
+			name: "unchanged",
+			nc:   ruleTestStruct{Handle: 1, Amount: 18446744073709551615, Signed: -9007199254740993, To: structs.ShineXYType{X: 1000000, Y: 2}},
+			want: ruleTestStruct{Handle: 1, Amount: 18446744073709551615, Signed: -9007199254740993, To: structs.ShineXYType{X: 1000000, Y: 2}},
+		},
+		{
+			name: "set",
+			nc:   ruleTestStruct{Handle: 1, Amount: 18446744073709551615, To: structs.ShineXYType{X: 1, Y: 2}},
+			set:  map[string]interface{}{"handle": 7, "to.x": 4000000, "amount": uint64(18446744073709551614)},
+			want: ruleTestStruct{Handle: 7, Amount: 18446744073709551614, To: structs.ShineXYType{X: 4000000, Y: 2}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := restruct.Pack(binary.LittleEndian, &tt.nc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fields, err := ncStructFields(ruleTestOpCode, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for path, v := range tt.set {
+				if err := setField(fields, path, v); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			packed, err := packNcStruct(ruleTestOpCode, fields)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if op := binary.LittleEndian.Uint16(packed); op != ruleTestOpCode {
+				t.Errorf("operation code %v, want %v", op, ruleTestOpCode)
+			}
+			var got ruleTestStruct
+			if err := restruct.Unpack(packed[2:], binary.LittleEndian, &got); err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// the log decodes the packets as rules changed them
+func TestProxyFramerLogsRules(t *testing.T) {
+	setupRuleStruct(t)
+
+	nc, err := restruct.Pack(binary.LittleEndian, &ruleTestStruct{Handle: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, 2, 2+len(nc))
+	binary.LittleEndian.PutUint16(data, ruleTestOpCode)
+	data = append(data, nc...)
+
+	f := &proxyFramer{
+		ps: &proxySession{
+			rules: packetRules{
+				{
+					OpCode: ruleTestOpCode,
+					Action: "set",
+					Set: map[string]interface{}{
+						"handle": 9,
+					},
+				},
+			},
+		},
+	}
+
+	framed := framePacket(data)
+	// the second half of the packet arrives later
+	out, logged := f.write(framed[:5])
+	if len(out) != 0 || len(logged) != 0 {
+		t.Fatalf("incomplete packet was sent: %v %v", out, logged)
+	}
+	out, logged = f.write(framed[5:])
+	if len(out) != 1 {
+		t.Fatalf("%v packets sent, want 1", len(out))
+	}
+	if !bytes.Equal(logged, out[0].data) {
+		t.Errorf("logged % x, sent % x", logged, out[0].data)
+	}
+
+	var got ruleTestStruct
+	if err := restruct.Unpack(logged[3:], binary.LittleEndian, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Handle != 9 {
+		t.Errorf("logged handle %v, want 9", got.Handle)
+	}
+}
@@ -0,0 +1,300 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/segmentio/ksuid"
+	"github.com/shine-o/shine.engine.core/networking"
+	"github.com/spf13/viper"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// session the connections of one player, from login to the zones
+type session struct {
+	ID      string        `json:"id"`
+	Account string        `json:"account,omitempty"`
+	Flows   []sessionFlow `json:"flows"`
+	// sessions found to be this one later on, e.g: by their account
+	mergedInto *session
+	clientIP   string
+	last       time.Time
+}
+
+// sessionFlow a connection of a session, and why it was linked to it
+type sessionFlow struct {
+	FlowID string    `json:"flow_id"`
+	Client string    `json:"client"`
+	Server string    `json:"server"`
+	Start  time.Time `json:"start"`
+	// handoff, proximity, account or new
+	LinkedBy string `json:"linked_by"`
+}
+
+// sessionAccount where a packet carries the account name, e.g: the login request
+type sessionAccount struct {
+	OpCode uint16 `mapstructure:"opCode"`
+	// field of the decoded struct, nested fields are separated by dots
+	Field string `mapstructure:"field"`
+}
+
+// pendingHandoff a server told a client to connect to another server
+type pendingHandoff struct {
+	session  *session
+	clientIP string
+	server   string
+	at       time.Time
+}
+
+// sessionTracker links streams into sessions
+type sessionTracker struct {
+	sessions map[string]*session
+	accounts map[string]*session
+	handoffs []pendingHandoff
+	// session.window, how long after a handoff or the last connection a new connection is linked
+	window        time.Duration
+	handoffFields map[uint16]handoffField
+	accountFields map[uint16]string
+	mu            sync.Mutex
+}
+
+var sessions *sessionTracker
+
+func loadSessionConfig() error {
+	sessions = &sessionTracker{
+		sessions:      make(map[string]*session),
+		accounts:      make(map[string]*session),
+		window:        viper.GetDuration("session.window"),
+		handoffFields: make(map[uint16]handoffField),
+		accountFields: make(map[uint16]string),
+	}
+
+	fields, err := loadHandoffFields()
+	if err != nil {
+		return err
+	}
+	for _, f := range fields {
+		sessions.handoffFields[f.OpCode] = f
+	}
+
+	var accounts []sessionAccount
+	if err := viper.UnmarshalKey("session.accounts", &accounts); err != nil {
+		return err
+	}
+	for _, a := range accounts {
+		sessions.accountFields[a.OpCode] = a.Field
+	}
+	return nil
+}
+
+// the session a merged session ended up in
+func (s *session) resolve() *session {
+	for s.mergedInto != nil {
+		s = s.mergedInto
+	}
+	return s
+}
+
+// link a new stream to the session it most likely belongs to
+// a handoff to its server is the best match, then the only session of the same client ip that was active recently
+func (st *sessionTracker) attach(ss *shineStream, at time.Time) {
+	client, server := ss.endpoints()
+	clientIP, _, _ := net.SplitHostPort(client)
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.prune(at)
+
+	var (
+		s        *session
+		linkedBy string
+	)
+
+	for i, h := range st.handoffs {
+		if h.clientIP == clientIP && h.server == server {
+			s = h.session.resolve()
+			linkedBy = "handoff"
+			st.handoffs = append(st.handoffs[:i], st.handoffs[i+1:]...)
+			break
+		}
+	}
+
+	if s == nil {
+		var recent []*session
+		for _, c := range st.sessions {
+			if c.mergedInto == nil && c.clientIP == clientIP && at.Sub(c.last) <= st.window {
+				recent = append(recent, c)
+			}
+		}
+		// more than one means several players behind the same address, it can't be told which
+		if len(recent) == 1 {
+			s = recent[0]
+			linkedBy = "proximity"
+		}
+	}
+
+	if s == nil {
+		s = &session{
+			ID:       ksuid.New().String(),
+			clientIP: clientIP,
+		}
+		st.sessions[s.ID] = s
+		linkedBy = "new"
+	}
+
+	s.Flows = append(s.Flows, sessionFlow{
+		FlowID:   ss.flowID,
+		Client:   client,
+		Server:   server,
+		Start:    at,
+		LinkedBy: linkedBy,
+	})
+	if at.After(s.last) {
+		s.last = at
+	}
+	ss.session = s
+
+	log.Infof("stream %v linked to session %v by %v", ss.flowID, s.ID, linkedBy)
+}
+
+// drop handoffs no client followed in time
+func (st *sessionTracker) prune(at time.Time) {
+	n := 0
+	for _, h := range st.handoffs {
+		if at.Sub(h.at) <= st.window {
+			st.handoffs[n] = h
+			n++
+		}
+	}
+	st.handoffs = st.handoffs[:n]
+}
+
+// look for handoffs and account names in a decoded packet
+func (ss *shineStream) trackSession(p *networking.Command, seen time.Time) {
+	st := sessions
+	if st == nil {
+		return
+	}
+	op := p.Base.OperationCode
+
+	if f, ok := st.handoffFields[op]; ok {
+		server, err := f.address(p.Base.Data)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		client, _ := ss.endpoints()
+		clientIP, _, _ := net.SplitHostPort(client)
+
+		st.mu.Lock()
+		s := ss.session.resolve()
+		st.handoffs = append(st.handoffs, pendingHandoff{
+			session:  s,
+			clientIP: clientIP,
+			server:   server,
+			at:       seen,
+		})
+		if seen.After(s.last) {
+			s.last = seen
+		}
+		st.mu.Unlock()
+
+		log.Infof("session %v handed off to %v", s.ID, server)
+	}
+
+	if field, ok := st.accountFields[op]; ok {
+		fields, err := ncStructFields(op, p.Base.Data)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		v, ok := getField(fields, field)
+		if !ok {
+			log.Errorf("session account field %v not found in opcode %v", field, op)
+			return
+		}
+		st.account(ss, fmt.Sprint(v))
+	}
+}
+
+// sessions with the same account are the same player, the stream's session is merged into the first one
+func (st *sessionTracker) account(ss *shineStream, account string) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	s := ss.session.resolve()
+	existing, ok := st.accounts[account]
+	if !ok {
+		s.Account = account
+		st.accounts[account] = s
+		return
+	}
+
+	existing = existing.resolve()
+	if existing == s {
+		return
+	}
+
+	for _, f := range s.Flows {
+		f.LinkedBy = "account"
+		existing.Flows = append(existing.Flows, f)
+	}
+	if s.last.After(existing.last) {
+		existing.last = s.last
+	}
+	s.Flows = nil
+	s.mergedInto = existing
+	delete(st.sessions, s.ID)
+
+	log.Infof("session %v merged into %v, both belong to account %v", s.ID, existing.ID, account)
+}
+
+// id of the session the stream belongs to
+func (ss *shineStream) sessionID() string {
+	if sessions == nil || ss.session == nil {
+		return ""
+	}
+	sessions.mu.Lock()
+	defer sessions.mu.Unlock()
+	return ss.session.resolve().ID
+}
+
+// write every session and its flows to output/sessions.json
+func exportSessions() {
+	if sessions == nil {
+		return
+	}
+	pathName, err := filepath.Abs("output/sessions.json")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	sessions.mu.Lock()
+	var list []*session
+	for _, s := range sessions.sessions {
+		list = append(list, s)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ID < list[j].ID
+	})
+	b, err := json.Marshal(list)
+	sessions.mu.Unlock()
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	if err := os.MkdirAll(filepath.Dir(pathName), 0700); err != nil {
+		log.Error(err)
+		return
+	}
+	if err := ioutil.WriteFile(pathName, b, 0666); err != nil {
+		log.Error(err)
+	}
+}
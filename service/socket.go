@@ -16,7 +16,11 @@ import (
 type PacketView struct {
 	// time of capture
 	PacketID         string                 `json:"packetID"`
+	Sequence         uint64                 `json:"sequence"`
 	ConnectionKey    string                 `json:"connectionKey"`
+	Interface        string                 `json:"interface,omitempty"`
+	Agent            string                 `json:"agent,omitempty"`
+	SessionID        string                 `json:"sessionID,omitempty"`
 	TimeStamp        string                 `json:"timestamp"`
 	IPEndpoints      string                 `json:"ipEndpoints"`
 	PortEndpoints    string                 `json:"portEndpoints"`
@@ -32,17 +36,15 @@ type webSockets struct {
 
 var upgrader = websocket.Upgrader{} // use default options
 
-var ws *webSockets // grrr, find other way to send packets to
+var ws = &webSockets{
+	cons: make(map[*websocket.Conn]bool),
+} // grrr, find other way to send packets to
 
 func startUI(ctx context.Context) {
 	select {
 	case <-ctx.Done():
 		return
 	default:
-		ws = &webSockets{
-			cons: make(map[*websocket.Conn]bool),
-		}
-
 		var addr = fmt.Sprintf("localhost:%v", viper.GetString("websocket.port"))
 		log.Infof("starting websocket server on %v", addr)
 		http.HandleFunc("/packets", packets)
@@ -61,6 +63,7 @@ func (pv *PacketView) String() string {
 
 func sendPacketToUI(pv PacketView) {
 	ws.mu.Lock()
+	defer ws.mu.Unlock()
 	// check if it can be done with goroutine
 	if len(ws.cons) == 0 {
 		return
@@ -75,12 +78,13 @@ func sendPacketToUI(pv PacketView) {
 		}
 		time.Sleep(time.Millisecond * 100)
 	}
-	ws.mu.Unlock()
 }
 
 type completedFlow struct {
 	FlowCompleted bool   `json:"flow_completed"`
 	FlowID        string `json:"flow_id"`
+	// closed, timeout or capture ended
+	Reason string `json:"reason,omitempty"`
 }
 
 func (cf *completedFlow) String() string {
@@ -0,0 +1,371 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"github.com/spf13/viper"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//go:generate go run ./internal/corestructs
+
+// structDefinition the struct assigned to an operation code in protocol.structs
+// either the name of a struct of shine.engine.core, - for none, or its fields
+type structDefinition struct {
+	Name   string            `mapstructure:"name"`
+	Struct string            `mapstructure:"struct"`
+	Fields []fieldDefinition `mapstructure:"fields"`
+}
+
+// fieldDefinition a field of a struct that isn't in shine.engine.core, fields are unpacked in order
+type fieldDefinition struct {
+	Name string `mapstructure:"name"`
+	Type string `mapstructure:"type"`
+	// size of bytes fields
+	Length int `mapstructure:"length"`
+	// elements of an array field
+	Count int `mapstructure:"count"`
+	// field that holds the number of elements of a list field
+	CountFrom string `mapstructure:"countFrom"`
+}
+
+// structRegistry structs of every operation code, reloaded when protocol.structs changes
+// operation codes named in protocol.commands get the struct of shine.engine.core with the same name,
+// NC_ACT_SOMEONESTOP_CMD is unpacked with NcActSomeoneStopCmd, protocol.structs overrides them
+type structRegistry struct {
+	types map[uint16]reflect.Type
+	// how each operation code got its struct, command or protocol.structs
+	sources map[uint16]string
+	// structs of shine.engine.core no operation code uses, their command names don't match
+	unwired []string
+	// operation codes protocol.structs took a matching struct away from
+	disabled []uint16
+	mu       sync.RWMutex
+}
+
+var (
+	ncStructs = &structRegistry{
+		types:   make(map[uint16]reflect.Type),
+		sources: make(map[uint16]string),
+	}
+	fieldName  = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*$`)
+	basicTypes = map[string]reflect.Type{
+		"uint8":   reflect.TypeOf(uint8(0)),
+		"uint16":  reflect.TypeOf(uint16(0)),
+		"uint32":  reflect.TypeOf(uint32(0)),
+		"uint64":  reflect.TypeOf(uint64(0)),
+		"int8":    reflect.TypeOf(int8(0)),
+		"int16":   reflect.TypeOf(int16(0)),
+		"int32":   reflect.TypeOf(int32(0)),
+		"int64":   reflect.TypeOf(int64(0)),
+		"float32": reflect.TypeOf(float32(0)),
+		"float64": reflect.TypeOf(float64(0)),
+	}
+)
+
+// new zero value of the struct assigned to the operation code, nil if there is none
+func (r *structRegistry) new(opCode uint16) interface{} {
+	r.mu.RLock()
+	t, ok := r.types[opCode]
+	r.mu.RUnlock()
+	if !ok {
+		return nil
+	}
+	return reflect.New(t).Interface()
+}
+
+func (r *structRegistry) has(opCode uint16) bool {
+	r.mu.RLock()
+	_, ok := r.types[opCode]
+	r.mu.RUnlock()
+	return ok
+}
+
+// read the definitions file, the structs in use are only replaced if every definition is valid
+func (r *structRegistry) load(path string) error {
+	v := viper.New()
+	v.SetConfigFile(path)
+	if err := v.ReadInConfig(); err != nil {
+		return err
+	}
+
+	var definitions map[string]structDefinition
+	if err := v.UnmarshalKey("structs", &definitions); err != nil {
+		return err
+	}
+	var layouts map[string][]fieldDefinition
+	if err := v.UnmarshalKey("layouts", &layouts); err != nil {
+		return err
+	}
+
+	sb := &structBuilder{
+		layouts:  layouts,
+		built:    make(map[string]reflect.Type),
+		building: make(map[string]bool),
+	}
+
+	types, sources := derivedStructs()
+	var (
+		disabled  []uint16
+		overrides int
+	)
+	for key, d := range definitions {
+		op, err := strconv.ParseUint(key, 0, 16)
+		if err != nil {
+			return fmt.Errorf("operation code %v: %v", key, err)
+		}
+		if d.Struct == "-" {
+			if _, ok := types[uint16(op)]; ok {
+				disabled = append(disabled, uint16(op))
+			}
+			delete(types, uint16(op))
+			delete(sources, uint16(op))
+			continue
+		}
+		t, err := sb.definition(d)
+		if err != nil {
+			return fmt.Errorf("operation code %v %v: %v", key, d.Name, err)
+		}
+		types[uint16(op)] = t
+		sources[uint16(op)] = "protocol.structs"
+		overrides++
+	}
+
+	used := make(map[reflect.Type]bool)
+	for _, t := range types {
+		used[t] = true
+	}
+	var unwired []string
+	for name, t := range coreStructs {
+		if !used[t] {
+			unwired = append(unwired, name)
+		}
+	}
+	sort.Strings(unwired)
+	sort.Slice(disabled, func(i, j int) bool {
+		return disabled[i] < disabled[j]
+	})
+
+	r.mu.Lock()
+	r.types = types
+	r.sources = sources
+	r.unwired = unwired
+	r.disabled = disabled
+	r.mu.Unlock()
+
+	log.Infof("loaded structs of %v operation codes, %v of them from %v", len(types), overrides, path)
+	if len(unwired) > 0 {
+		log.Warningf("structs without an operation code, their command names don't match and need an entry in %v: %v", path, unwired)
+	}
+	return nil
+}
+
+// structs of the operation codes in protocol.commands whose names match a struct of shine.engine.core
+func derivedStructs() (map[uint16]reflect.Type, map[uint16]string) {
+	byKey := make(map[string]reflect.Type)
+	for name, t := range coreStructs {
+		byKey[structKey(name)] = t
+	}
+
+	types := make(map[uint16]reflect.Type)
+	sources := make(map[uint16]string)
+	for op, name := range commandNames {
+		if t, ok := byKey[structKey(name)]; ok {
+			types[op] = t
+			sources[op] = "command"
+		}
+	}
+	return types, sources
+}
+
+// NC_ACT_SOMEONESTOP_CMD and NcActSomeoneStopCmd are both ncactsomeonestopcmd
+func structKey(name string) string {
+	return strings.ToLower(strings.Replace(name, "_", "", -1))
+}
+
+// reload the definitions whenever the file is modified, a broken file keeps the previous structs
+// returns once ctx is done
+func (r *structRegistry) watch(ctx context.Context, path string, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	var mod time.Time
+	if fi, err := os.Stat(path); err == nil {
+		mod = fi.ModTime()
+	}
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		fi, err := os.Stat(path)
+		if err != nil || fi.ModTime().Equal(mod) {
+			continue
+		}
+		mod = fi.ModTime()
+		if err := r.load(path); err != nil {
+			log.Errorf("could not reload struct definitions, keeping the previous ones: %v", err)
+		}
+	}
+}
+
+// structBuilder turns field definitions into struct types restruct can unpack
+type structBuilder struct {
+	layouts  map[string][]fieldDefinition
+	built    map[string]reflect.Type
+	building map[string]bool
+}
+
+func (sb *structBuilder) definition(d structDefinition) (reflect.Type, error) {
+	switch {
+	case d.Struct != "" && len(d.Fields) > 0:
+		return nil, fmt.Errorf("either struct or fields can be set")
+	case d.Struct != "":
+		t, ok := coreStructs[d.Struct]
+		if !ok {
+			return nil, fmt.Errorf("unknown struct %v", d.Struct)
+		}
+		return t, nil
+	case len(d.Fields) > 0:
+		return sb.structOf(d.Fields)
+	default:
+		return nil, fmt.Errorf("no struct or fields")
+	}
+}
+
+// layout names are case insensitive, the definitions file keys are lowercased when it's read
+func (sb *structBuilder) layout(name string) (reflect.Type, bool, error) {
+	key := strings.ToLower(name)
+	if t, ok := sb.built[key]; ok {
+		return t, true, nil
+	}
+	fields, ok := sb.layouts[key]
+	if !ok {
+		return nil, false, nil
+	}
+	if sb.building[key] {
+		return nil, true, fmt.Errorf("layout %v contains itself", name)
+	}
+	sb.building[key] = true
+	t, err := sb.structOf(fields)
+	sb.building[key] = false
+	if err != nil {
+		return nil, true, fmt.Errorf("layout %v: %v", name, err)
+	}
+	sb.built[key] = t
+	return t, true, nil
+}
+
+func (sb *structBuilder) structOf(fields []fieldDefinition) (reflect.Type, error) {
+	var sfs []reflect.StructField
+	seen := make(map[string]bool)
+	for _, f := range fields {
+		if !fieldName.MatchString(f.Name) {
+			return nil, fmt.Errorf("invalid field name %q", f.Name)
+		}
+		name := exportedName(f.Name)
+		if seen[name] {
+			return nil, fmt.Errorf("field %v is defined twice", f.Name)
+		}
+
+		t, err := sb.fieldType(f)
+		if err != nil {
+			return nil, fmt.Errorf("field %v: %v", f.Name, err)
+		}
+
+		tag := fmt.Sprintf(`json:"%v"`, f.Name)
+		if f.CountFrom != "" {
+			if !seen[exportedName(f.CountFrom)] {
+				return nil, fmt.Errorf("field %v: countFrom %v isn't a field before it", f.Name, f.CountFrom)
+			}
+			tag += fmt.Sprintf(` struct:"sizefrom=%v"`, exportedName(f.CountFrom))
+		}
+
+		seen[name] = true
+		sfs = append(sfs, reflect.StructField{
+			Name: name,
+			Type: t,
+			Tag:  reflect.StructTag(tag),
+		})
+	}
+	return reflect.StructOf(sfs), nil
+}
+
+func (sb *structBuilder) fieldType(f fieldDefinition) (reflect.Type, error) {
+	var t reflect.Type
+	if f.Type == "bytes" {
+		if f.Length <= 0 {
+			return nil, fmt.Errorf("bytes need a length")
+		}
+		t = reflect.ArrayOf(f.Length, reflect.TypeOf(byte(0)))
+	} else if bt, ok := basicTypes[f.Type]; ok {
+		t = bt
+	} else if ct, ok := coreStructs[f.Type]; ok {
+		t = ct
+	} else {
+		lt, ok, err := sb.layout(f.Type)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, fmt.Errorf("unknown type %v", f.Type)
+		}
+		t = lt
+	}
+
+	switch {
+	case f.Count > 0 && f.CountFrom != "":
+		return nil, fmt.Errorf("either count or countFrom can be set")
+	case f.Count > 0:
+		return reflect.ArrayOf(f.Count, t), nil
+	case f.CountFrom != "":
+		return reflect.SliceOf(t), nil
+	}
+	return t, nil
+}
+
+func exportedName(name string) string {
+	return strings.ToUpper(name[:1]) + name[1:]
+}
+
+// structInfo an operation code and its struct, as listed by the structs command
+type structInfo struct {
+	OpCode  uint16
+	Command string
+	Struct  string
+	Source  string
+}
+
+// every operation code with a struct, in order
+func (r *structRegistry) list() []structInfo {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var infos []structInfo
+	for op, t := range r.types {
+		name := t.Name()
+		if name == "" {
+			name = "fields"
+		}
+		infos = append(infos, structInfo{
+			OpCode:  op,
+			Command: commandNames[op],
+			Struct:  name,
+			Source:  r.sources[op],
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].OpCode < infos[j].OpCode
+	})
+	return infos
+}
@@ -0,0 +1,339 @@
+package service
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/shine-o/shine.engine.core/structs"
+)
+
+func TestStructKey(t *testing.T) {
+	tests := []struct {
+		command string
+		name    string
+		want    bool
+	}{
+		{"NC_ACT_SOMEONESTOP_CMD", "NcActSomeoneStopCmd", true},
+		{"NC_MISC_SEED_ACK", "NcMiscSeedAck", true},
+		{"NC_MAP_LOGIN_REQ", "NcMapLoginReq", true},
+		{"NC_MAP_LOGIN_REQ", "NcMapLoginAck", false},
+		{"NC_ACT_CHAT_REQ", "NcActChatReqs", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.command+" "+tt.name, func(t *testing.T) {
+			if got := structKey(tt.command) == structKey(tt.name); got != tt.want {
+				t.Errorf("%v and %v match %v, want %v", structKey(tt.command), structKey(tt.name), got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDerivedStructs(t *testing.T) {
+	defer func(names map[uint16]string) {
+		commandNames = names
+	}(commandNames)
+
+	tests := []struct {
+		name     string
+		commands map[uint16]string
+		want     map[uint16]reflect.Type
+	}{
+		{
+			name:     "no commands",
+			commands: map[uint16]string{},
+			want:     map[uint16]reflect.Type{},
+		},
+		{
+			name: "matching names",
+			commands: map[uint16]string{
+				2055: "NC_MISC_SEED_ACK",
+				6145: "NC_MAP_LOGIN_REQ",
+				8211: "NC_ACT_SOMEONESTOP_CMD",
+			},
+			want: map[uint16]reflect.Type{
+				2055: reflect.TypeOf(structs.NcMiscSeedAck{}),
+				6145: reflect.TypeOf(structs.NcMapLoginReq{}),
+				8211: reflect.TypeOf(structs.NcActSomeoneStopCmd{}),
+			},
+		},
+		{
+			name: "names without a struct",
+			commands: map[uint16]string{
+				2055:   "NC_MISC_SEED_ACK",
+				0xfff1: "NC_TEST_NOTHING_CMD",
+			},
+			want: map[uint16]reflect.Type{
+				2055: reflect.TypeOf(structs.NcMiscSeedAck{}),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			commandNames = tt.commands
+			types, sources := derivedStructs()
+			if !reflect.DeepEqual(types, tt.want) {
+				t.Errorf("got %v, want %v", types, tt.want)
+			}
+			if len(sources) != len(types) {
+				t.Fatalf("%v sources for %v structs", len(sources), len(types))
+			}
+			for op, source := range sources {
+				if source != "command" {
+					t.Errorf("operation code %v source %v, want command", op, source)
+				}
+			}
+		})
+	}
+}
+
+// every operation code the decoder had a struct for before protocol.structs
+var baselineStructs = []struct {
+	opCode uint16
+	want   reflect.Type
+}{
+	{2053, reflect.TypeOf(structs.NcMiscHeartBeatAck{})},
+	{2055, reflect.TypeOf(structs.NcMiscSeedAck{})},
+	{2062, reflect.TypeOf(structs.NcMiscGameTimeAck{})},
+	{3082, reflect.TypeOf(structs.NcUserLoginAck{})},
+	{3084, reflect.TypeOf(structs.NcUserWorldSelectAck{})},
+	{3087, reflect.TypeOf(structs.NcUserLoginWorldReq{})},
+	{3092, reflect.TypeOf(structs.NcUserLoginWorldAck{})},
+	{3162, reflect.TypeOf(structs.NcUserUsLoginReq{})},
+	{3173, reflect.TypeOf(structs.NcUserClientVersionCheckReq{})},
+	{4097, reflect.TypeOf(structs.NcCharLoginReq{})},
+	{4099, reflect.TypeOf(structs.NcCharLoginAck{})},
+	{4114, reflect.TypeOf(structs.NcCharGuildCmd{})},
+	{4152, reflect.TypeOf(structs.NcCharClientBaseCmd{})},
+	{4153, reflect.TypeOf(structs.NcCharClientShapeCmd{})},
+	{4154, reflect.TypeOf(structs.NcCharClientQuestDoingCmd{})},
+	{4155, reflect.TypeOf(structs.NcCharClientQuestDoneCmd{})},
+	{4157, reflect.TypeOf(structs.NcCharClientSkillCmd{})},
+	{4158, reflect.TypeOf(structs.NcCharClientPassiveCmd{})},
+	{4167, reflect.TypeOf(structs.NcCharClientItemCmd{})},
+	{4169, reflect.TypeOf(structs.NcClientCharTitleCmd{})},
+	{4170, reflect.TypeOf(structs.NcCharClientChargedBuffCmd{})},
+	{4187, reflect.TypeOf(structs.NcCharStatRemainPointCmd{})},
+	{4247, reflect.TypeOf(structs.NcCharGuildAcademyCmd{})},
+	{4286, reflect.TypeOf(structs.NcCharClientAutoPickCmd{})},
+	{4294, reflect.TypeOf(structs.NcCharAdminLevelInformCmd{})},
+	{4302, reflect.TypeOf(structs.NcCharClientQuestReadCmd{})},
+	{4311, reflect.TypeOf(structs.NcCharClientQuestRepeatCmd{})},
+	{4314, reflect.TypeOf(structs.NcCharNewbieGuideViewSetCmd{})},
+	{4318, reflect.TypeOf(structs.NcCharClientCoinInfoCmd{})},
+	{4396, reflect.TypeOf(structs.NcCharUseItemMinimonUseBroadCmd{})},
+	{6145, reflect.TypeOf(structs.NcMapLoginReq{})},
+	{6146, reflect.TypeOf(structs.NcMapLoginAck{})},
+	{6147, reflect.TypeOf(structs.NcMapLoginCompleteCmd{})},
+	{6154, reflect.TypeOf(structs.NcMapLinkOtherCmd{})},
+	{6170, reflect.TypeOf(structs.NcMapTownPortalReq{})},
+	{6171, reflect.TypeOf(structs.NcMapTownPortalAck{})},
+	{6183, reflect.TypeOf(structs.NcMapFieldAttributeCmd{})},
+	{6187, reflect.TypeOf(structs.NcMapCanUseReviveItemCmd{})},
+	{7169, reflect.TypeOf(structs.NcBriefInfoInformCmd{})},
+	{7170, reflect.TypeOf(structs.NcBriefInfoChangeDecorateCmd{})},
+	{7171, reflect.TypeOf(structs.NcBriefInfoChangeUpgradeCmd{})},
+	{7172, reflect.TypeOf(structs.NcBriefInfoUnequipCmd{})},
+	{7173, reflect.TypeOf(structs.NcBriefInfoChangeWeaponCmd{})},
+	{7174, reflect.TypeOf(structs.NcBriefInfoLoginCharacterCmd{})},
+	{7175, reflect.TypeOf(structs.NcBriefInfoCharacterCmd{})},
+	{7176, reflect.TypeOf(structs.NcBriefInfoRegenMobCmd{})},
+	{7177, reflect.TypeOf(structs.NcBriefInfoMobCmd{})},
+	{7178, reflect.TypeOf(structs.NcBriefInfoDroppedItemCmd{})},
+	{7182, reflect.TypeOf(structs.NcBriefInfoDeleteCmd{})},
+	{7192, reflect.TypeOf(structs.NcBriefInfoAbstateChangeCmd{})},
+	{7193, reflect.TypeOf(structs.NcBriefInfoAbstateChangeListCmd{})},
+	{7194, reflect.TypeOf(structs.NcBriefInfoRegenMoverCmd{})},
+	{7195, reflect.TypeOf(structs.NcBriefInfoMoverCmd{})},
+	{8193, reflect.TypeOf(structs.NcActChatReq{})},
+	{8200, reflect.TypeOf(structs.NcActChangeModeReq{})},
+	{8201, reflect.TypeOf(structs.NcActSomeoneChangeModeCmd{})},
+	{8202, reflect.TypeOf(structs.NcActNpcClickCmd{})},
+	{8210, reflect.TypeOf(structs.NcActStopReq{})},
+	{8211, reflect.TypeOf(structs.NcActSomeoneStopCmd{})},
+	{8216, reflect.TypeOf(structs.NcActSomeoneMoveWalkCmd{})},
+	{8217, reflect.TypeOf(structs.NcActMoveRunCmd{})},
+	{8218, reflect.TypeOf(structs.NcActSomeoneMoveRunCmd{})},
+	{8223, reflect.TypeOf(structs.NcActSomeoneShoutCmd{})},
+	{8229, reflect.TypeOf(structs.NcActSomeoneJumpCmd{})},
+	{8236, reflect.TypeOf(structs.NcActSomeoneFoldTentCmd{})},
+	{8237, reflect.TypeOf(structs.NcActGatherStartReq{})},
+	{8248, reflect.TypeOf(structs.NcActSomeoneProduceCastCmd{})},
+	{8252, reflect.TypeOf(structs.NcActSomeoneProduceMakeCmd{})},
+	{8254, reflect.TypeOf(structs.NcActMoveSpeedCmd{})},
+	{9218, reflect.TypeOf(structs.NcBatTargetInfoCmd{})},
+	{9230, reflect.TypeOf(structs.NcBatHpChangeCmd{})},
+	{9231, reflect.TypeOf(structs.NcBatSpChangeCmd{})},
+	{9255, reflect.TypeOf(structs.NcBatAbstateSetCmd{})},
+	{9256, reflect.TypeOf(structs.NcBatAbstateResetCmd{})},
+	{9257, reflect.TypeOf(structs.NcBatAbstateInformCmd{})},
+	{9258, reflect.TypeOf(structs.NcBatAbstateInformNoEffectCmd{})},
+	{9276, reflect.TypeOf(structs.NcBatDotDamageCmd{})},
+	{9277, reflect.TypeOf(structs.NcBatCeaseFireCmd{})},
+	{9280, reflect.TypeOf(structs.NcBatSkillBashObjCastReq{})},
+	{9295, reflect.TypeOf(structs.NcBatSomeoneSkillBashHitObjStartCmd{})},
+	{9298, reflect.TypeOf(structs.NcBatSkillBashHitDamageCmd{})},
+	{9303, reflect.TypeOf(structs.NcBatSkillBashHitBlastCmd{})},
+	{9311, reflect.TypeOf(structs.NcBatLpChangeCmd{})},
+	{12289, reflect.TypeOf(structs.NcItemCellChangeCmd{})},
+	{12295, reflect.TypeOf(structs.NcItemDropReq{})},
+	{12296, reflect.TypeOf(structs.NcItemDropAck(0))},
+	{12297, reflect.TypeOf(structs.NcItemPickReq{})},
+	{12298, reflect.TypeOf(structs.NcItemPickAck{})},
+	{12299, reflect.TypeOf(structs.NcitemRelocateReq{})},
+	{12303, reflect.TypeOf(structs.NcItemEquipReq{})},
+	{12306, reflect.TypeOf(structs.NcItemUnequipReq{})},
+	{12309, reflect.TypeOf(structs.NcItemUseReq{})},
+	{12320, reflect.TypeOf(structs.NcITemChargedInventoryOpenReq{})},
+	{12321, reflect.TypeOf(structs.NcItemChangedInventoryOpenAck{})},
+	{12332, reflect.TypeOf(structs.NcItemRewardInventoryOpenReq{})},
+	{12333, reflect.TypeOf(structs.NcItemRewardInventoryOpenAck{})},
+	{15361, reflect.TypeOf(structs.NcServerMenuReq{})},
+	{15362, reflect.TypeOf(structs.NcServerMenuAck{})},
+	{16421, reflect.TypeOf(structs.NcCharUiStateSaveReq{})},
+	{17410, reflect.TypeOf(structs.NcQuestScriptCmdAck{})},
+	{17428, reflect.TypeOf(structs.NcQuestStartReq{})},
+	{17438, reflect.TypeOf(structs.NcQuestResetTimeClientCmd{})},
+	{20491, reflect.TypeOf(structs.NcSoulStoneHpSomeoneUseCmd{})},
+	{20492, reflect.TypeOf(structs.NcSoulStoneSpSomeoneUseCmd{})},
+	{22556, reflect.TypeOf(structs.NcKqListTimeAck{})},
+	{22586, reflect.TypeOf(structs.NcKqTeamTypeCmd{})},
+	{26627, reflect.TypeOf(structs.NcBoothSomeoneOpenCmd{})},
+	{26631, reflect.TypeOf(structs.NcBoothEntryReq{})},
+	{26632, reflect.TypeOf(structs.NcBoothEntrySellAck{})},
+	{26634, reflect.TypeOf(structs.NcBoothRefreshReq{})},
+	{26647, reflect.TypeOf(structs.NcBoothSearchBoothClosedCmd{})},
+	{28676, reflect.TypeOf(structs.NcCharOptionGetShortcutSizeReq{})},
+	{28677, reflect.TypeOf(structs.NcCharOptionGetShortcutSizeAck{})},
+	{28685, reflect.TypeOf(structs.NcCharOptionGetWindowPosAck{})},
+	{28722, reflect.TypeOf(structs.NcCharGetShortcutDataCmd{})},
+	{28723, reflect.TypeOf(structs.NcCharGetKeyMapCmd{})},
+	{28724, reflect.TypeOf(structs.NcCharOptionImproveGetGameOptionCmd{})},
+	{31751, reflect.TypeOf(structs.NcPrisonGetAck{})},
+	{36880, reflect.TypeOf(structs.NcChargedBoothSlotSizeCmd{})},
+	{37908, reflect.TypeOf(structs.NcHolyPromiseListCmd{})},
+	{50184, reflect.TypeOf(structs.NcCollectCardRegisterReq{})},
+	{52226, reflect.TypeOf(structs.NcMoverRideOnCmd{})},
+	{52228, reflect.TypeOf(structs.NcMoverSomeoneRideOnCmd{})},
+	{52232, reflect.TypeOf(structs.NcMoverSomeoneRideOffCmd{})},
+	{52234, reflect.TypeOf(structs.NcMoverHungryCmd{})},
+	{52237, reflect.TypeOf(structs.NcMoverMoveSpeedCmd{})},
+}
+
+func TestBaselineStructsRegistered(t *testing.T) {
+	names, err := loadCommandNames("../config/commands.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(names map[uint16]string) {
+		commandNames = names
+	}(commandNames)
+	commandNames = names
+
+	r := &structRegistry{}
+	if err := r.load("../config/structs.yml"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range baselineStructs {
+		if got := r.types[tt.opCode]; got != tt.want {
+			t.Errorf("operation code %v %v: got %v, want %v", tt.opCode, names[tt.opCode], got, tt.want)
+		}
+	}
+}
+
+func TestLoadStructErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		yml  string
+	}{
+		{"unknown struct", "structs:\n  8229:\n    name: NC_ACT_SOMEEONEJUMP_CMD\n    struct: NcActSomeoneJumpCommand\n"},
+		{"struct and fields", "structs:\n  8229:\n    struct: NcActSomeoneJumpCmd\n    fields:\n      - name: handle\n        type: uint16\n"},
+		{"invalid operation code", "structs:\n  jump:\n    struct: NcActSomeoneJumpCmd\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := ioutil.TempFile("", "structs-*.yml")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.Remove(f.Name())
+			if _, err := f.WriteString(tt.yml); err != nil {
+				t.Fatal(err)
+			}
+			f.Close()
+
+			r := &structRegistry{}
+			if err := r.load(f.Name()); err == nil {
+				t.Errorf("loaded %v", r.types)
+			}
+		})
+	}
+}
+
+// the watcher reloads the file when it changes, and returns once its context is done
+func TestStructsWatch(t *testing.T) {
+	const opCode = 0xfff1
+	write := func(path, yml string, mod time.Time) {
+		t.Helper()
+		if err := ioutil.WriteFile(path, []byte(yml), 0666); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mod, mod); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fields := func(r *structRegistry) int {
+		r.mu.RLock()
+		defer r.mu.RUnlock()
+		if typ, ok := r.types[opCode]; ok {
+			return typ.NumField()
+		}
+		return 0
+	}
+
+	f, err := ioutil.TempFile("", "structs-*.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+	mod := time.Now().Add(-time.Minute)
+	write(f.Name(), "structs:\n  65521:\n    fields:\n      - name: handle\n        type: uint16\n", mod)
+
+	r := &structRegistry{}
+	if err := r.load(f.Name()); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		r.watch(ctx, f.Name(), 5*time.Millisecond)
+	}()
+	// the watcher keeps the modification time it starts with
+	time.Sleep(20 * time.Millisecond)
+
+	write(f.Name(), "structs:\n  65521:\n    fields:\n      - name: handle\n        type: uint16\n      - name: amount\n        type: uint32\n", mod.Add(time.Second))
+	deadline := time.Now().Add(time.Second)
+	for fields(r) != 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := fields(r); n != 2 {
+		t.Errorf("struct has %v fields after the file changed, want 2", n)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("watcher still running after its context was canceled")
+	}
+}
@@ -0,0 +1,88 @@
+package service
+
+import (
+	"encoding/binary"
+	"github.com/shine-o/shine.engine.core/networking"
+	"github.com/spf13/viper"
+)
+
+// xorRecovery protocol.xorRecovery, finds the xor offset of client streams that were captured after NC_MISC_SEED_ACK
+type xorRecovery struct {
+	enabled bool
+	// complete packets needed before offsets are tried
+	minPackets int
+}
+
+// packets tried at most for each offset
+const xorRecoveryMaxPackets = 32
+
+var (
+	xorLimit       uint16
+	xorRecoverySet xorRecovery
+)
+
+func loadXorRecoveryConfig() {
+	xorRecoverySet = xorRecovery{
+		enabled:    viper.GetBool("protocol.xorRecovery.enabled"),
+		minPackets: viper.GetInt("protocol.xorRecovery.minPackets"),
+	}
+}
+
+// try every xor offset against the packets buffered in data, which starts at a packet boundary
+// an offset is valid if every packet it deciphers has an operation code listed in protocol.commands
+// more is set if there aren't enough packets yet, or more than one offset is valid,
+// otherwise no offset is valid for data and more packets won't change it
+func recoverXorOffset(data []byte) (xorOffset uint16, found, more bool) {
+	if !xorRecoverySet.enabled || len(commandNames) == 0 || xorLimit == 0 {
+		return 0, false, false
+	}
+
+	var packets [][]byte
+	offset := 0
+	for offset < len(data) && len(packets) < xorRecoveryMaxPackets {
+		start, end, ok := packetBounds(data, offset)
+		if !ok || end > len(data) {
+			break
+		}
+		packets = append(packets, data[start:end])
+		offset = end
+	}
+
+	if len(packets) < xorRecoverySet.minPackets {
+		return 0, false, true
+	}
+
+	var (
+		offsets []uint16
+		buf     []byte
+	)
+	for candidate := uint16(0); candidate < xorLimit; candidate++ {
+		x := candidate
+		valid := true
+		for _, p := range packets {
+			if len(p) < 2 {
+				valid = false
+				break
+			}
+			// the whole packet is deciphered, as the offset moves with every byte
+			buf = append(buf[:0], p...)
+			networking.XorCipher(buf, &x)
+			if _, ok := commandNames[binary.LittleEndian.Uint16(buf)]; !ok {
+				valid = false
+				break
+			}
+		}
+		if valid {
+			offsets = append(offsets, candidate)
+		}
+	}
+
+	if len(offsets) != 1 {
+		if len(offsets) > 1 {
+			log.Warningf("%v xor offsets are valid for %v packets, waiting for more", len(offsets), len(packets))
+			return 0, false, true
+		}
+		return 0, false, false
+	}
+	return offsets[0], true, false
+}